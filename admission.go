@@ -0,0 +1,147 @@
+package filecache
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// AdmissionPolicy decides whether a file being cached by a read or by
+// Cache is worth displacing the item the eviction policy would evict to
+// make room for it; files cached with CacheNow are always admitted.
+// Record is called every time a file is requested, whether or not it is
+// in the cache. Unlike EvictionPolicy, implementations must be safe for
+// concurrent use.
+type AdmissionPolicy interface {
+	Record(name string)                  // name has been requested
+	Admit(candidate, victim string) bool // should candidate replace victim?
+}
+
+const (
+	sketchDepth   = 4  // number of rows in the count-min sketch
+	sketchMax     = 15 // counters saturate at this value
+	sampleFactor  = 10 // window size, as a multiple of the capacity
+	doorkeeperMul = 8  // doorkeeper bits per sketch column
+)
+
+// TinyLFU is an admission policy in the style of W-TinyLFU: request
+// frequencies are estimated with a count-min sketch fronted by a
+// doorkeeper Bloom filter, so that files seen only once never reach the
+// sketch. A candidate is admitted only if it has been requested more often
+// than the victim it would replace. Every 10 * capacity requests, all
+// counts are halved so that the estimates track recent popularity.
+type TinyLFU struct {
+	mutex    sync.Mutex
+	counters []uint8
+	door     []uint64
+	mask     uint64
+	doorMask uint64
+	added    int
+	sample   int
+}
+
+// NewTinyLFU returns a TinyLFU admission policy sized for a cache holding
+// approximately capacity items; usually this is the cache's MaxItems.
+func NewTinyLFU(capacity int) *TinyLFU {
+	if capacity < 1 {
+		capacity = DefaultMaxItems
+	}
+	sample := sampleFactor * capacity
+	width := uint64(16)
+	for width < uint64(sample) {
+		width <<= 1
+	}
+	return &TinyLFU{
+		counters: make([]uint8, sketchDepth*width),
+		door:     make([]uint64, width*doorkeeperMul/64),
+		mask:     width - 1,
+		doorMask: width*doorkeeperMul - 1,
+		sample:   sample,
+	}
+}
+
+func hashName(name string) (h1, h2 uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	sum := h.Sum64()
+	return sum, (sum >> 32) | 1
+}
+
+// doorkeep adds the name to the doorkeeper, returning false if it was
+// already present.
+func (t *TinyLFU) doorkeep(h1, h2 uint64) bool {
+	added := false
+	for i := uint64(0); i < 2; i++ {
+		bit := (h1 + i*h2) & t.doorMask
+		if t.door[bit/64]&(1<<(bit%64)) == 0 {
+			t.door[bit/64] |= 1 << (bit % 64)
+			added = true
+		}
+	}
+	return added
+}
+
+func (t *TinyLFU) inDoor(h1, h2 uint64) bool {
+	for i := uint64(0); i < 2; i++ {
+		bit := (h1 + i*h2) & t.doorMask
+		if t.door[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *TinyLFU) counter(row, h1, h2 uint64) *uint8 {
+	col := (h1 + row*h2) & t.mask
+	return &t.counters[row*(t.mask+1)+col]
+}
+
+// reset halves every counter and clears the doorkeeper.
+func (t *TinyLFU) reset() {
+	for i := range t.counters {
+		t.counters[i] >>= 1
+	}
+	for i := range t.door {
+		t.door[i] = 0
+	}
+	t.added /= 2
+}
+
+// Record notes a request for name.
+func (t *TinyLFU) Record(name string) {
+	h1, h2 := hashName(name)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !t.doorkeep(h1, h2) {
+		for row := uint64(0); row < sketchDepth; row++ {
+			if c := t.counter(row, h1, h2); *c < sketchMax {
+				*c++
+			}
+		}
+	}
+	t.added++
+	if t.added >= t.sample {
+		t.reset()
+	}
+}
+
+// Estimate returns the approximate number of recent requests for name.
+func (t *TinyLFU) Estimate(name string) int {
+	h1, h2 := hashName(name)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	est := uint8(sketchMax)
+	for row := uint64(0); row < sketchDepth; row++ {
+		if c := *t.counter(row, h1, h2); c < est {
+			est = c
+		}
+	}
+	if t.inDoor(h1, h2) {
+		return int(est) + 1
+	}
+	return int(est)
+}
+
+// Admit returns true if candidate has been requested more often than victim.
+func (t *TinyLFU) Admit(candidate, victim string) bool {
+	return t.Estimate(candidate) > t.Estimate(victim)
+}
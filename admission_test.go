@@ -0,0 +1,88 @@
+package filecache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTinyLFUEstimate(t *testing.T) {
+	fmt.Printf("[+] testing TinyLFU frequency estimates: ")
+	filter := NewTinyLFU(8)
+	for i := 0; i < 5; i++ {
+		filter.Record("hot")
+	}
+	filter.Record("cold")
+
+	if est := filter.Estimate("hot"); est < 5 {
+		fmt.Println("failed")
+		fmt.Printf("[!] hot item estimated at %d requests\n", est)
+		t.FailNow()
+	} else if est := filter.Estimate("cold"); est != 1 {
+		fmt.Println("failed")
+		fmt.Printf("[!] cold item estimated at %d requests\n", est)
+		t.FailNow()
+	} else if filter.Admit("cold", "hot") || !filter.Admit("hot", "cold") {
+		fmt.Println("failed")
+		fmt.Println("[!] admission should favour the more frequent item")
+		t.FailNow()
+	}
+
+	for i := 0; i < 10*8; i++ {
+		filter.Record(fmt.Sprintf("scan%d", i))
+	}
+	if est := filter.Estimate("hot"); est >= 5 {
+		fmt.Println("failed")
+		fmt.Printf("[!] estimates should age; hot item still at %d\n", est)
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}
+
+func TestAdmissionFilter(t *testing.T) {
+	fmt.Printf("[+] validating admission filter protects hot items: ")
+	cache := NewDefaultCache()
+	cache.MaxItems = 2
+	cache.Admission = NewTinyLFU(cache.MaxItems)
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+	}
+
+	hot := writeTempFile(t, "hot file")
+	warm := writeTempFile(t, "warm file")
+	cold := writeTempFile(t, "cold file")
+	names := []string{hot, warm, cold}
+	if t.Failed() {
+		cache.Stop()
+		destroyNames(names)
+		t.FailNow()
+	}
+
+	cache.CacheNow(hot)
+	cache.CacheNow(warm)
+	for i := 0; i < 4; i++ {
+		cache.ReadFile(hot)
+		cache.ReadFile(warm)
+	}
+
+	cache.ReadFile(cold)
+	time.Sleep(50 * time.Millisecond)
+	if cache.InCache(cold) || !cache.InCache(hot) || !cache.InCache(warm) {
+		fmt.Println("failed")
+		fmt.Println("[!] cold item should not displace hot items")
+		t.Fail()
+	} else if err := cache.addItem(cold, true); err != ItemNotAdmitted {
+		fmt.Println("failed")
+		fmt.Println("[!] expected ItemNotAdmitted, got", err)
+		t.Fail()
+	} else if err := cache.CacheNow(cold); err != nil || !cache.InCache(cold) {
+		fmt.Println("failed")
+		fmt.Println("[!] CacheNow should bypass the admission filter")
+		t.Fail()
+	} else {
+		fmt.Println("ok")
+	}
+	cache.Stop()
+	destroyNames(names)
+}
@@ -0,0 +1,119 @@
+package filecache
+
+import (
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A CacheRule sets the Cache-Control and Expires headers sent with files
+// whose request paths match its Pattern. Patterns are matched as described
+// for the Handler's Exclude field: ".css" matches an extension, "/static/"
+// a path prefix, and "*.min.js" a glob.
+type CacheRule struct {
+	Pattern   string
+	MaxAge    time.Duration // How long clients may reuse the response
+	Immutable bool          // The file never changes while fresh
+	NoCache   bool          // Clients must revalidate before reuse
+}
+
+// header returns the Cache-Control header for the rule.
+func (rule *CacheRule) header() string {
+	if rule.NoCache {
+		return "no-cache"
+	}
+	value := "public, max-age=" + strconv.FormatInt(int64(rule.MaxAge/time.Second), 10)
+	if rule.Immutable {
+		value += ", immutable"
+	}
+	return value
+}
+
+// isFingerprint returns true if s looks like a content hash: at least
+// eight hexadecimal digits, mixing decimal digits and letters, so that
+// dates and numeric IDs aren't taken for hashes.
+func isFingerprint(s string) bool {
+	if len(s) < 8 {
+		return false
+	}
+	digit, letter := false, false
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			digit = true
+		case (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F'):
+			letter = true
+		default:
+			return false
+		}
+	}
+	return digit && letter
+}
+
+// HasFingerprint returns true if the file name in the request path upath
+// contains a content hash, as build tools add to the names of assets, such
+// as "app.3f9a1c2b.js" or "index-5e2b7a90.css". A hash is a dot- or
+// dash-separated run of at least eight hexadecimal digits that includes
+// both a decimal digit and a letter.
+func HasFingerprint(upath string) bool {
+	base := path.Base(upath)
+	base = strings.TrimSuffix(base, path.Ext(base))
+	for _, part := range strings.FieldsFunc(base, func(r rune) bool {
+		return r == '.' || r == '-'
+	}) {
+		if isFingerprint(part) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheRule returns the rule for the request path upath, or nil if no rule
+// applies. Fingerprinted files take precedence over the CacheRules table.
+func (h *Handler) cacheRule(upath string) *CacheRule {
+	if h.FingerprintMaxAge > 0 && h.fingerprinted(upath) {
+		return &CacheRule{MaxAge: h.FingerprintMaxAge, Immutable: true}
+	}
+	for i := range h.CacheRules {
+		if matchPath(h.CacheRules[i].Pattern, upath) {
+			return &h.CacheRules[i]
+		}
+	}
+	return nil
+}
+
+// urlPath returns the request path for the file named by 'name' beneath
+// the root.
+func (h *Handler) urlPath(name string) string {
+	rel, err := filepath.Rel(h.root, name)
+	if err != nil || rel == "." {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+// setCacheHeaders sets the caching headers for the file named by 'name'.
+func (h *Handler) setCacheHeaders(w http.ResponseWriter, name string) {
+	rule := h.cacheRule(h.urlPath(name))
+	if rule == nil {
+		return
+	}
+	header := w.Header()
+	header.Set("cache-control", rule.header())
+	if !rule.NoCache {
+		expires := time.Now().Add(rule.MaxAge).UTC()
+		header.Set("expires", expires.Format(http.TimeFormat))
+	}
+}
+
+// fingerprinted returns true if the request path upath names a file with
+// a content hash in its name.
+func (h *Handler) fingerprinted(upath string) bool {
+	if h.Fingerprinted != nil {
+		return h.Fingerprinted(upath)
+	}
+	return HasFingerprint(upath)
+}
@@ -0,0 +1,109 @@
+package filecache
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFingerprinted(t *testing.T) {
+	fmt.Printf("[+] validating fingerprinted file detection: ")
+	tests := map[string]bool{
+		"/app.3f9a1c2b.js":           true,
+		"/assets/index-5e2b7a90.css": true,
+		"/main.3F9A1C2B.chunk.js":    true,
+		"/3f9a1c2b.js":               true,
+		"/app.js":                    false,
+		"/facade.js":                 false,
+		"/app.3f9a.js":               false,
+		"/app.3f9g1c.js":             false,
+		"/3f9a1c2b/app.js":           false,
+		"/app.3f9a1c.js":             false,
+		"/app.deadbeef.js":           false,
+		"/invoice-100234.pdf":        false,
+		"/photos/20240615.jpg":       false,
+	}
+	for upath, expected := range tests {
+		if HasFingerprint(upath) != expected {
+			fmt.Println("failed")
+			fmt.Printf("[!] HasFingerprint(%q) should be %v\n", upath, expected)
+			t.FailNow()
+		}
+	}
+	fmt.Println("ok")
+}
+
+func TestCacheRules(t *testing.T) {
+	fmt.Printf("[+] validating Cache-Control rules: ")
+	dir, root := makeTree(t, map[string]string{
+		"public/index.html":        "<h1>home</h1>",
+		"public/app.3f9a1c2b.js":   "app()",
+		"public/static/logo.svg":   "<svg/>",
+		"public/static/style.css":  "body {}",
+		"public/static/lib.min.js": "lib()",
+	})
+	defer os.RemoveAll(dir)
+
+	cache := NewDefaultCache()
+	h := HttpHandlerRoot(cache, root)
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+	}
+	defer cache.Stop()
+	h.FingerprintMaxAge = 365 * 24 * time.Hour
+	h.CacheRules = []CacheRule{
+		{Pattern: ".html", NoCache: true},
+		{Pattern: "*.min.js", MaxAge: time.Hour, Immutable: true},
+		{Pattern: ".CSS", MaxAge: time.Minute},
+		{Pattern: "/static/", MaxAge: time.Hour},
+	}
+
+	tests := []struct {
+		target  string
+		control string
+		expires bool
+	}{
+		{"/", "no-cache", false},
+		{"/app.3f9a1c2b.js", "public, max-age=31536000, immutable", true},
+		{"/static/lib.min.js", "public, max-age=3600, immutable", true},
+		{"/static/style.css", "public, max-age=60", true},
+		{"/static/logo.svg", "public, max-age=3600", true},
+		{"/static/missing.svg", "", false},
+	}
+	for _, test := range tests {
+		w := serve(h, "GET", test.target)
+		control := w.Header().Get("cache-control")
+		expires := w.Header().Get("expires")
+		if control != test.control || (expires != "") != test.expires {
+			fmt.Println("failed")
+			fmt.Printf("[!] %s returned Cache-Control %q and Expires %q, expected %q\n",
+				test.target, control, expires, test.control)
+			t.FailNow()
+		}
+		if test.expires {
+			when, err := http.ParseTime(expires)
+			if err != nil || when.Before(time.Now()) {
+				fmt.Println("failed")
+				fmt.Printf("[!] %s returned bad Expires header %q\n", test.target, expires)
+				t.FailNow()
+			}
+		}
+	}
+
+	// A custom matcher replaces the default.
+	h.Fingerprinted = func(upath string) bool { return strings.HasPrefix(upath, "/static/") }
+	if control := serve(h, "GET", "/static/style.css").Header().Get("cache-control"); control != "public, max-age=31536000, immutable" {
+		fmt.Println("failed")
+		fmt.Printf("[!] custom fingerprinted file returned Cache-Control %q\n", control)
+		t.FailNow()
+	} else if control = serve(h, "GET", "/app.3f9a1c2b.js").Header().Get("cache-control"); control != "" {
+		fmt.Println("failed")
+		fmt.Printf("[!] file not matched by custom matcher returned Cache-Control %q\n", control)
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}
@@ -0,0 +1,103 @@
+package filecache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strconv"
+	"strings"
+)
+
+// variant is a compressed copy of a cached file.
+type variant struct {
+	content []byte
+	etag    string
+}
+
+// Supported content encodings, in order of preference, and the extension
+// of the precompressed files holding them.
+var encodings = []struct {
+	name string
+	ext  string
+}{
+	{"br", ".br"},
+	{"zstd", ".zst"},
+	{"gzip", ".gz"},
+}
+
+// loadVariants returns the compressed variants to store alongside the file
+// named by 'name'. Precompressed files are only used if they are smaller
+// than MaxSize; generated variants are only kept if they are smaller than
+// the original content.
+func (cache *FileCache) loadVariants(name string, content []byte) map[string]*variant {
+	variants := make(map[string]*variant)
+	if cache.Precompressed {
+		for _, enc := range encodings {
+			fi, err := cache.stat(name + enc.ext)
+			if err != nil || !fi.Mode().IsRegular() || fi.Size() > cache.MaxSize {
+				continue
+			}
+			data, err := cache.readFile(name + enc.ext)
+			if err == nil {
+				variants[enc.name] = &variant{data, contentTag(data)}
+			}
+		}
+	}
+	if cache.Compress && variants["gzip"] == nil {
+		buf := new(bytes.Buffer)
+		zw, _ := gzip.NewWriterLevel(buf, gzip.BestCompression)
+		zw.Write(content)
+		zw.Close()
+		if buf.Len() < len(content) {
+			data := buf.Bytes()
+			variants["gzip"] = &variant{data, contentTag(data)}
+		}
+	}
+	if len(variants) == 0 {
+		return nil
+	}
+	return variants
+}
+
+// negotiateEncoding picks the encoding to use from those available, given
+// a request's Accept-Encoding header. It returns the empty string if the
+// file should be sent unencoded.
+func negotiateEncoding(accept string, available map[string]*variant) string {
+	var (
+		best     string
+		bestQ    float64
+		wildcard = -1.0
+		quality  = make(map[string]float64)
+	)
+	for _, part := range strings.Split(accept, ",") {
+		coding, params := part, ""
+		if i := strings.IndexByte(part, ';'); i >= 0 {
+			coding, params = part[:i], part[i+1:]
+		}
+		q := 1.0
+		if p := strings.TrimSpace(params); strings.HasPrefix(p, "q=") {
+			if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+				q = v
+			}
+		}
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "*" {
+			wildcard = q
+		} else if coding != "" {
+			quality[coding] = q
+		}
+	}
+
+	for _, enc := range encodings {
+		if available[enc.name] == nil {
+			continue
+		}
+		q, ok := quality[enc.name]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = enc.name, q
+		}
+	}
+	return best
+}
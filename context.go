@@ -0,0 +1,131 @@
+package filecache
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// ctxReader is an io.Reader that fails with its context's error once the
+// context is done, so that copies from it can be cancelled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// copyContext copies r to w until it is done or ctx is.
+func copyContext(ctx context.Context, w io.Writer, r io.Reader) (int64, error) {
+	return io.Copy(w, &ctxReader{ctx, r})
+}
+
+// copyFile copies the file named by 'name' from the cache's file system
+// to w, without caching it.
+func (cache *FileCache) copyFile(ctx context.Context, w io.Writer, name string) error {
+	file, err := cache.open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	n, err := copyContext(ctx, w, file)
+	cache.stats.add(&cache.stats.diskBytes, int(n))
+	return err
+}
+
+// copyItem copies a cached file to w.
+func (cache *FileCache) copyItem(ctx context.Context, w io.Writer, itm *cacheItem) error {
+	n, err := copyContext(ctx, w, bytes.NewReader(itm.Access()))
+	cache.served(int(n))
+	return err
+}
+
+// loadContext is like load, but stops waiting for the file once ctx is
+// done. The file is still cached.
+func (cache *FileCache) loadContext(ctx context.Context, name string, filter bool) (*cacheItem, error) {
+	return cache.loads.doContext(ctx, loadKey(name, filter), func() (*cacheItem, error) {
+		return cache.loadItem(name, filter)
+	})
+}
+
+// ReadFileContext is like ReadFile, but returns the context's error if ctx
+// is done before the file has been read. If the file was being read into
+// the cache, it is still cached.
+func (cache *FileCache) ReadFileContext(ctx context.Context, name string) (content []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if cache.InCache(name) {
+		if itm, ok := cache.accessItem(name); ok {
+			content = append([]byte(nil), itm.Access()...)
+			cache.served(len(content))
+			return
+		}
+	} else {
+		cache.miss(name)
+	}
+
+	itm, err := cache.loadContext(ctx, name, true)
+	switch {
+	case itm != nil:
+		content = append([]byte(nil), itm.content...)
+		cache.served(len(content))
+	case err == ItemTooLarge:
+		buf := new(bytes.Buffer)
+		if err = cache.copyFile(ctx, buf, name); err != nil {
+			return nil, err
+		}
+		content = buf.Bytes()
+	default:
+		return
+	}
+	err = nil
+	if !SquelchItemNotInCache {
+		err = ItemNotInCache
+	}
+	return
+}
+
+// WriteFileContext is like WriteFile, but stops waiting for the file to be
+// read, or stops writing it, once ctx is done, returning the context's
+// error. If the file was being read into the cache, it is still cached.
+func (cache *FileCache) WriteFileContext(ctx context.Context, w io.Writer, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if cache.InCache(name) {
+		if itm, ok := cache.accessItem(name); ok {
+			return cache.copyItem(ctx, w, itm)
+		}
+	} else {
+		cache.miss(name)
+	}
+
+	itm, err := cache.loadContext(ctx, name, true)
+	switch {
+	case itm != nil:
+		return cache.copyItem(ctx, w, itm)
+	case err == ItemTooLarge:
+		return cache.copyFile(ctx, w, name)
+	}
+	return err
+}
+
+// CacheContext is like Cache, but gives up waiting for room in the queue
+// of files to cache once ctx is done, returning the context's error. It
+// returns nil once the file has been queued or dropped, or if the cache is
+// stopped.
+func (cache *FileCache) CacheContext(ctx context.Context, name string) error {
+	cache.lock()
+	in, shutdown := cache.in, cache.shutdown
+	cache.unlock()
+	if in == nil {
+		return nil
+	}
+	return cache.enqueue(ctx, in, shutdown, name)
+}
@@ -0,0 +1,146 @@
+package filecache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// cancelWriter cancels its context after the first write.
+type cancelWriter struct {
+	n      int
+	cancel context.CancelFunc
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	w.cancel()
+	w.n += len(p)
+	return len(p), nil
+}
+
+func TestReadFileContext(t *testing.T) {
+	fmt.Printf("[+] testing reads with a context: ")
+	content := []byte("console.log('hello');")
+	fsys := &slowFS{delay: 100 * time.Millisecond, MapFS: fstest.MapFS{
+		"app.js": {Data: content},
+	}}
+	cache := NewFSCache(fsys)
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+		t.FailNow()
+	}
+	defer cache.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cache.ReadFileContext(ctx, "app.js"); err != context.Canceled {
+		fmt.Println("failed")
+		fmt.Printf("[!] read with a cancelled context returned %v\n", err)
+		t.FailNow()
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := cache.ReadFileContext(ctx, "app.js"); err != context.DeadlineExceeded {
+		fmt.Println("failed")
+		fmt.Printf("[!] read past its deadline returned %v\n", err)
+		t.FailNow()
+	}
+
+	// The abandoned read should still be cached, and shared with the
+	// next caller.
+	data, err := cache.ReadFileContext(context.Background(), "app.js")
+	if err != nil || !bytes.Equal(data, content) {
+		fmt.Println("failed")
+		fmt.Printf("[!] read returned %q, %v\n", data, err)
+		t.FailNow()
+	} else if reads := atomic.LoadInt64(&fsys.reads); !cache.InCache("app.js") || reads != 1 {
+		fmt.Println("failed")
+		fmt.Printf("[!] file should have been read once and cached, but was read %d times\n",
+			reads)
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}
+
+func TestWriteFileContext(t *testing.T) {
+	fmt.Printf("[+] testing writes with a context: ")
+	content := bytes.Repeat([]byte("0123456789abcdef"), 16*1024)
+	cache := NewFSCache(fstest.MapFS{
+		"small.txt": {Data: content[:64]},
+		"big.bin":   {Data: content},
+	})
+	cache.MaxSize = 1024
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+		t.FailNow()
+	}
+	defer cache.Stop()
+
+	buf := new(bytes.Buffer)
+	if err := cache.WriteFileContext(context.Background(), buf, "small.txt"); err != nil ||
+		!bytes.Equal(buf.Bytes(), content[:64]) {
+		fmt.Println("failed")
+		fmt.Printf("[!] write returned %v\n", err)
+		t.FailNow()
+	}
+
+	// Files too large to cache are copied in chunks, so the copy should
+	// stop part way through.
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &cancelWriter{cancel: cancel}
+	err := cache.WriteFileContext(ctx, w, "big.bin")
+	if err != context.Canceled || w.n == 0 || w.n >= len(content) {
+		fmt.Println("failed")
+		fmt.Printf("[!] cancelled write returned %v after %d bytes\n", err, w.n)
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}
+
+func TestCacheContext(t *testing.T) {
+	fmt.Printf("[+] testing queueing files with a context: ")
+	pipeSize := NewCachePipeSize
+	NewCachePipeSize = 1
+	defer func() { NewCachePipeSize = pipeSize }()
+
+	fsys := &slowFS{delay: 200 * time.Millisecond, MapFS: fstest.MapFS{
+		"a.txt": {Data: []byte("a")},
+		"b.txt": {Data: []byte("b")},
+		"c.txt": {Data: []byte("c")},
+	}}
+	cache := NewFSCache(fsys)
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+		t.FailNow()
+	}
+	defer cache.Stop()
+
+	// The first file keeps the cache busy, and the second fills the
+	// queue once the first has been taken from it.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := cache.CacheContext(ctx, name); err != nil {
+			fmt.Println("failed")
+			fmt.Printf("[!] failed to queue %s: %v\n", name, err)
+			t.FailNow()
+		}
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := cache.CacheContext(ctx, "c.txt"); err != context.DeadlineExceeded {
+		fmt.Println("failed")
+		fmt.Printf("[!] queueing to a full queue returned %v\n", err)
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}
@@ -37,12 +37,13 @@
         fmt.Printf("[+] read %d bytes\n", len(readme))
      }
 
-  You can transparently read and cache a file using RetrieveFile (and
-  RetrieveFileString); if the file is not in the cache, it will be read
-  from the file system and returned - the cache will start a background
-  thread to cache the file. Similarly, the WriterFile method will write
-  the file to the specified io.Writer. For example, you could create a
-  FileServer function along the lines of
+  You can transparently read and cache a file using ReadFile (and
+  ReadFileString); if the file is not in the cache, it is read from the
+  file system and cached before it is returned, by the calling goroutine.
+  Concurrent reads of a file that isn't cached share a single read from
+  the file system. Similarly, the WriteFile method will write the file to
+  the specified io.Writer. For example, you could create a FileServer
+  function along the lines of
 
      func FileServer(w http.ResponseWriter, r *http.Request) {
              path := r.URL.Path
@@ -74,12 +75,14 @@
         field to 0; in this case, the cache will only expire items that have
         been modified since caching or that satisfy the next condition.
      3. Is the cache at capacity? When a file is being cached, a check is
-        made to see if the cache is currently filled. If it is, the item that
-        was last accessed the longest ago is expired and the new item takes
-        its place. When loading items asynchronously, this check might miss
-        the fact that the cache will be at capacity; the background scanner
-        performs a check after its regular checks to ensure that the cache is
-        not at capacity.
+        made to see if the cache is currently filled. If it is, the item chosen
+        by the cache's eviction policy (by default, the item that was last
+        accessed the longest ago) is expired and the new item takes its place.
+        If MaxBytes is set, items are also expired until the total size of the
+        cached files leaves room for the new item. When loading items
+        asynchronously, this check might miss the fact that the cache will be
+        at capacity; the background scanner performs a check after its regular
+        checks to ensure that the cache is not at capacity.
 
   The background scanner can be disabled by setting cache.Every to 0; if so,
   cache expiration is only done when the cache is at capacity.
@@ -0,0 +1,332 @@
+package filecache
+
+// EvictionPolicy decides which item should be removed from the cache when
+// it is at capacity. The cache notifies the policy as items are inserted,
+// accessed and removed, and asks it for a victim when room must be made;
+// the victim is only dropped from the policy once the cache calls Remove.
+// Policy methods are called with the cache lock held, so implementations
+// do not need to do their own locking. A sharded cache has a policy for
+// each shard, which only sees the items in that shard.
+type EvictionPolicy interface {
+	Insert(name string)     // name has been added to the cache
+	Access(name string)     // name has been read from the cache
+	Remove(name string)     // name has been removed from the cache
+	Victim() (string, bool) // name that should be evicted next, if any
+}
+
+// entry is a node in an entryList. Policies keep entries in a map keyed by
+// name, so moving or removing a name is a constant time operation that
+// does not allocate.
+type entry struct {
+	name       string
+	prev, next *entry
+}
+
+// entryList is an intrusive, circular doubly-linked list of entries; the
+// front of the list holds the most recently inserted or touched entry.
+type entryList struct {
+	root entry
+	len  int
+}
+
+func (l *entryList) init() *entryList {
+	l.root.prev = &l.root
+	l.root.next = &l.root
+	l.len = 0
+	return l
+}
+
+func (l *entryList) pushFront(e *entry) {
+	e.prev = &l.root
+	e.next = l.root.next
+	e.prev.next = e
+	e.next.prev = e
+	l.len++
+}
+
+func (l *entryList) remove(e *entry) {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	e.prev = nil
+	e.next = nil
+	l.len--
+}
+
+func (l *entryList) moveToFront(e *entry) {
+	if l.root.next == e {
+		return
+	}
+	l.remove(e)
+	l.pushFront(e)
+}
+
+// back returns the least recently touched entry, or nil if the list is
+// empty.
+func (l *entryList) back() *entry {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.prev
+}
+
+// lruPolicy evicts the item that was last accessed the longest ago. Items
+// are kept in recency order, so both accesses and victim selection take
+// constant time.
+type lruPolicy struct {
+	entries map[string]*entry
+	recency entryList
+}
+
+// NewLRUPolicy returns a least-recently-used eviction policy. This is the
+// default policy.
+func NewLRUPolicy() EvictionPolicy {
+	p := &lruPolicy{entries: make(map[string]*entry)}
+	p.recency.init()
+	return p
+}
+
+func (p *lruPolicy) Insert(name string) {
+	if e, ok := p.entries[name]; ok {
+		p.recency.moveToFront(e)
+		return
+	}
+	e := &entry{name: name}
+	p.entries[name] = e
+	p.recency.pushFront(e)
+}
+
+func (p *lruPolicy) Access(name string) {
+	if e, ok := p.entries[name]; ok {
+		p.recency.moveToFront(e)
+	}
+}
+
+func (p *lruPolicy) Remove(name string) {
+	if e, ok := p.entries[name]; ok {
+		p.recency.remove(e)
+		delete(p.entries, name)
+	}
+}
+
+func (p *lruPolicy) Victim() (string, bool) {
+	if e := p.recency.back(); e != nil {
+		return e.name, true
+	}
+	return "", false
+}
+
+type lfuEntry struct {
+	count uint64
+	used  uint64
+}
+
+// lfuPolicy evicts the item that has been accessed the fewest times; ties
+// are broken by evicting the least recently used of them.
+type lfuPolicy struct {
+	clock   uint64
+	entries map[string]*lfuEntry
+}
+
+// NewLFUPolicy returns a least-frequently-used eviction policy.
+func NewLFUPolicy() EvictionPolicy {
+	return &lfuPolicy{entries: make(map[string]*lfuEntry)}
+}
+
+func (p *lfuPolicy) Insert(name string) {
+	p.clock++
+	p.entries[name] = &lfuEntry{count: 1, used: p.clock}
+}
+
+func (p *lfuPolicy) Access(name string) {
+	if ent, ok := p.entries[name]; ok {
+		p.clock++
+		ent.count++
+		ent.used = p.clock
+	}
+}
+
+func (p *lfuPolicy) Remove(name string) {
+	delete(p.entries, name)
+}
+
+func (p *lfuPolicy) Victim() (victim string, ok bool) {
+	var least *lfuEntry
+	for name, ent := range p.entries {
+		if least == nil || ent.count < least.count ||
+			(ent.count == least.count && ent.used < least.used) {
+			victim, least = name, ent
+		}
+	}
+	return victim, least != nil
+}
+
+// fifoPolicy evicts the item that was added to the cache first, regardless
+// of how it has been accessed since.
+type fifoPolicy struct {
+	entries map[string]*entry
+	queue   entryList
+}
+
+// NewFIFOPolicy returns a first-in, first-out eviction policy.
+func NewFIFOPolicy() EvictionPolicy {
+	p := &fifoPolicy{entries: make(map[string]*entry)}
+	p.queue.init()
+	return p
+}
+
+func (p *fifoPolicy) Insert(name string) {
+	if e, ok := p.entries[name]; ok {
+		p.queue.moveToFront(e)
+		return
+	}
+	e := &entry{name: name}
+	p.entries[name] = e
+	p.queue.pushFront(e)
+}
+
+func (p *fifoPolicy) Access(name string) {}
+
+func (p *fifoPolicy) Remove(name string) {
+	if e, ok := p.entries[name]; ok {
+		p.queue.remove(e)
+		delete(p.entries, name)
+	}
+}
+
+func (p *fifoPolicy) Victim() (string, bool) {
+	if e := p.queue.back(); e != nil {
+		return e.name, true
+	}
+	return "", false
+}
+
+// Lists an arcEntry may be on.
+const (
+	arcT1 = iota // resident, seen once recently
+	arcT2        // resident, seen at least twice recently
+	arcB1        // ghost, evicted from T1
+	arcB2        // ghost, evicted from T2
+)
+
+type arcEntry struct {
+	entry
+	list int
+}
+
+// arcPolicy implements the Adaptive Replacement Cache algorithm. Resident
+// items are split between a recency list (T1) for items seen once and a
+// frequency list (T2) for items seen more than once; the names of items
+// evicted from each are remembered on ghost lists (B1 and B2). A hit on a
+// ghost list shifts the target size of T1, so the policy tunes itself
+// towards whichever of recency or frequency is working for the current
+// workload. Items that are only ever touched once, such as those read by a
+// batch scan, cycle through T1 without disturbing T2.
+//
+// The capacity used to bound the ghost lists is the largest number of
+// resident items the policy has seen, which converges on the cache's
+// MaxItems as the cache fills.
+type arcPolicy struct {
+	entries  map[string]*arcEntry
+	lists    [4]entryList
+	target   int // target size of T1
+	capacity int
+}
+
+// NewARCPolicy returns a scan-resistant Adaptive Replacement Cache eviction
+// policy.
+func NewARCPolicy() EvictionPolicy {
+	p := &arcPolicy{entries: make(map[string]*arcEntry)}
+	for i := range p.lists {
+		p.lists[i].init()
+	}
+	return p
+}
+
+func (p *arcPolicy) move(e *arcEntry, list int) {
+	p.lists[e.list].remove(&e.entry)
+	e.list = list
+	p.lists[list].pushFront(&e.entry)
+}
+
+func (p *arcPolicy) drop(list int) {
+	e := p.lists[list].back()
+	p.lists[list].remove(e)
+	delete(p.entries, e.name)
+}
+
+// trim keeps the ghost lists within the bounds set by the capacity.
+func (p *arcPolicy) trim() {
+	t1, t2 := &p.lists[arcT1], &p.lists[arcT2]
+	b1, b2 := &p.lists[arcB1], &p.lists[arcB2]
+	for b1.len > 0 && t1.len+b1.len > p.capacity {
+		p.drop(arcB1)
+	}
+	for b2.len > 0 && t1.len+t2.len+b1.len+b2.len > 2*p.capacity {
+		p.drop(arcB2)
+	}
+}
+
+func (p *arcPolicy) Insert(name string) {
+	e, ok := p.entries[name]
+	switch {
+	case !ok:
+		e = &arcEntry{entry: entry{name: name}, list: arcT1}
+		p.entries[name] = e
+		p.lists[arcT1].pushFront(&e.entry)
+	case e.list == arcB1:
+		delta := 1
+		if b1, b2 := p.lists[arcB1].len, p.lists[arcB2].len; b2 > b1 {
+			delta = b2 / b1
+		}
+		if p.target += delta; p.target > p.capacity {
+			p.target = p.capacity
+		}
+		p.move(e, arcT2)
+	case e.list == arcB2:
+		delta := 1
+		if b1, b2 := p.lists[arcB1].len, p.lists[arcB2].len; b1 > b2 {
+			delta = b1 / b2
+		}
+		if p.target -= delta; p.target < 0 {
+			p.target = 0
+		}
+		p.move(e, arcT2)
+	default:
+		p.move(e, arcT2)
+	}
+
+	if n := p.lists[arcT1].len + p.lists[arcT2].len; n > p.capacity {
+		p.capacity = n
+	}
+	p.trim()
+}
+
+func (p *arcPolicy) Access(name string) {
+	if e, ok := p.entries[name]; ok && (e.list == arcT1 || e.list == arcT2) {
+		p.move(e, arcT2)
+	}
+}
+
+func (p *arcPolicy) Remove(name string) {
+	e, ok := p.entries[name]
+	if !ok {
+		return
+	}
+	switch e.list {
+	case arcT1:
+		p.move(e, arcB1)
+	case arcT2:
+		p.move(e, arcB2)
+	}
+	p.trim()
+}
+
+func (p *arcPolicy) Victim() (string, bool) {
+	t1, t2 := &p.lists[arcT1], &p.lists[arcT2]
+	if t1.len > 0 && (t1.len > p.target || t2.len == 0) {
+		return t1.back().name, true
+	} else if t2.len > 0 {
+		return t2.back().name, true
+	}
+	return "", false
+}
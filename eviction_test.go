@@ -0,0 +1,155 @@
+package filecache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func checkVictim(t *testing.T, policy EvictionPolicy, expected string) {
+	victim, ok := policy.Victim()
+	if !ok || victim != expected {
+		fmt.Println("failed")
+		fmt.Printf("[!] expected victim %s, got %s\n", expected, victim)
+		t.FailNow()
+	}
+	policy.Remove(victim)
+}
+
+func TestLRUPolicy(t *testing.T) {
+	fmt.Printf("[+] testing LRU eviction order: ")
+	policy := NewLRUPolicy()
+	policy.Insert("a")
+	policy.Insert("b")
+	policy.Insert("c")
+	policy.Access("a")
+	checkVictim(t, policy, "b")
+	checkVictim(t, policy, "c")
+	checkVictim(t, policy, "a")
+	if _, ok := policy.Victim(); ok {
+		fmt.Println("failed")
+		fmt.Println("[!] empty policy should not return a victim")
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}
+
+func TestLFUPolicy(t *testing.T) {
+	fmt.Printf("[+] testing LFU eviction order: ")
+	policy := NewLFUPolicy()
+	policy.Insert("a")
+	policy.Insert("b")
+	policy.Insert("c")
+	policy.Access("a")
+	policy.Access("a")
+	policy.Access("c")
+	checkVictim(t, policy, "b")
+	checkVictim(t, policy, "c")
+	checkVictim(t, policy, "a")
+	fmt.Println("ok")
+}
+
+func TestFIFOPolicy(t *testing.T) {
+	fmt.Printf("[+] testing FIFO eviction order: ")
+	policy := NewFIFOPolicy()
+	policy.Insert("a")
+	policy.Insert("b")
+	policy.Insert("c")
+	policy.Access("a")
+	checkVictim(t, policy, "a")
+	checkVictim(t, policy, "b")
+	checkVictim(t, policy, "c")
+	fmt.Println("ok")
+}
+
+func TestCacheEvictionPolicy(t *testing.T) {
+	fmt.Printf("[+] validating cache uses configured eviction policy: ")
+	cache := NewDefaultCache()
+	cache.MaxItems = 2
+	cache.Eviction = NewLFUPolicy
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+	}
+
+	first := writeTempFile(t, "first file")
+	second := writeTempFile(t, "second file")
+	third := writeTempFile(t, "third file")
+	names := []string{first, second, third}
+	if t.Failed() {
+		cache.Stop()
+		destroyNames(names)
+		t.FailNow()
+	}
+
+	cache.CacheNow(first)
+	cache.CacheNow(second)
+	cache.GetItem(first)
+	cache.GetItem(first)
+	cache.CacheNow(third)
+
+	if !cache.InCache(first) || cache.InCache(second) || !cache.InCache(third) {
+		fmt.Println("failed")
+		fmt.Println("[!] least frequently used item should have been evicted")
+		t.Fail()
+	} else {
+		fmt.Println("ok")
+	}
+	cache.Stop()
+	destroyNames(names)
+}
+
+// simulateCache drives policy as a cache holding capacity items would,
+// returning the set of resident names after requesting each name in turn.
+func simulateCache(policy EvictionPolicy, capacity int, requests []string) map[string]bool {
+	resident := make(map[string]bool)
+	for _, name := range requests {
+		if resident[name] {
+			policy.Access(name)
+			continue
+		}
+		if len(resident) >= capacity {
+			victim, _ := policy.Victim()
+			policy.Remove(victim)
+			delete(resident, victim)
+		}
+		policy.Insert(name)
+		resident[name] = true
+	}
+	return resident
+}
+
+func TestARCPolicy(t *testing.T) {
+	fmt.Printf("[+] testing ARC scan resistance: ")
+	requests := []string{"a", "b", "c", "d", "a", "b"}
+	for i := 0; i < 16; i++ {
+		requests = append(requests, fmt.Sprintf("scan%d", i))
+	}
+
+	resident := simulateCache(NewLRUPolicy(), 4, requests)
+	if resident["a"] || resident["b"] {
+		fmt.Println("failed")
+		fmt.Println("[!] scan should flush an LRU cache")
+		t.FailNow()
+	}
+
+	resident = simulateCache(NewARCPolicy(), 4, requests)
+	if !resident["a"] || !resident["b"] {
+		fmt.Println("failed")
+		fmt.Println("[!] frequently used items should survive a scan")
+		t.FailNow()
+	}
+
+	// Once the scanned items are requested again, ARC should adapt and
+	// keep them in preference to items that are no longer being used.
+	working := requests[len(requests)-4:]
+	requests = append(requests, working...)
+	resident = simulateCache(NewARCPolicy(), 4, requests)
+	for _, name := range working {
+		if !resident[name] {
+			fmt.Println("failed")
+			fmt.Printf("[!] %s should be resident after repeated use\n", name)
+			t.FailNow()
+		}
+	}
+	fmt.Println("ok")
+}
@@ -0,0 +1,37 @@
+package filecache
+
+import "expvar"
+
+// expvarState is the cache state published by Publish.
+type expvarState struct {
+	MaxItems   int    `json:"max_items"`
+	MaxSize    int64  `json:"max_size"`
+	ExpireItem int    `json:"expire_item"`
+	Every      int    `json:"every"`
+	Items      int    `json:"items"`
+	Bytes      int64  `json:"bytes"`
+	Hits       uint64 `json:"hits"`
+	Misses     uint64 `json:"misses"`
+}
+
+func (cache *FileCache) expvarState() interface{} {
+	stats := cache.Stats()
+	return expvarState{
+		MaxItems:   cache.MaxItems,
+		MaxSize:    cache.MaxSize,
+		ExpireItem: cache.ExpireItem,
+		Every:      cache.Every,
+		Items:      stats.Items,
+		Bytes:      stats.Bytes,
+		Hits:       stats.Hits,
+		Misses:     stats.Misses,
+	}
+}
+
+// Publish registers an expvar variable under name that reports the
+// cache's configuration, size and hit and miss counts as JSON whenever
+// it is read, for example from /debug/vars. Like expvar.Publish, it
+// panics if name is already registered.
+func (cache *FileCache) Publish(name string) {
+	expvar.Publish(name, expvar.Func(cache.expvarState))
+}
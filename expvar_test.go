@@ -0,0 +1,58 @@
+package filecache
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"testing"
+	"testing/fstest"
+)
+
+// publishRuns makes the names published by TestPublish unique, since
+// expvar names can't be reused when the test is run more than once.
+var publishRuns int
+
+func TestPublish(t *testing.T) {
+	fmt.Printf("[+] testing expvar publication: ")
+	cache := NewFSCache(fstest.MapFS{"a.txt": {Data: []byte("hello")}})
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+		t.FailNow()
+	}
+	defer cache.Stop()
+	publishRuns++
+	name := fmt.Sprintf("filecache_test_%d", publishRuns)
+	cache.Publish(name)
+	cache.ReadFile("a.txt")
+	cache.ReadFile("a.txt")
+
+	v := expvar.Get(name)
+	if v == nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache wasn't published")
+		t.FailNow()
+	}
+	var state expvarState
+	if err := json.Unmarshal([]byte(v.String()), &state); err != nil {
+		fmt.Println("failed")
+		fmt.Printf("[!] published invalid JSON %s: %v\n", v, err)
+		t.FailNow()
+	}
+	expect := expvarState{
+		MaxItems:   cache.MaxItems,
+		MaxSize:    cache.MaxSize,
+		ExpireItem: cache.ExpireItem,
+		Every:      cache.Every,
+		Items:      1,
+		Bytes:      5,
+		Hits:       1,
+		Misses:     1,
+	}
+	if state != expect {
+		fmt.Println("failed")
+		fmt.Printf("[!] expected %+v, got %+v\n", expect, state)
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}
@@ -2,16 +2,19 @@ package filecache
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"mime"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -28,13 +31,17 @@ const (
 var (
 	DefaultExpireItem int   = 300 // 5 minutes
 	DefaultMaxSize    int64 = 16 * Megabyte
+	DefaultMaxBytes   int64 = 0 // no limit
 	DefaultMaxItems   int   = 32
 	DefaultEvery      int   = 60 // 1 minute
+	DefaultShards     int   = 1
+	DefaultWorkers    int   = 1
 )
 
 var (
 	InvalidCacheItem = errors.New("invalid cache item")
 	ItemIsDirectory  = errors.New("can't cache a directory")
+	ItemNotAdmitted  = errors.New("item not admitted to cache")
 	ItemNotInCache   = errors.New("item not in cache")
 	ItemTooLarge     = errors.New("item too large for cache")
 	WriteIncomplete  = errors.New("incomplete write of cache item")
@@ -49,8 +56,12 @@ type cacheItem struct {
 	content    []byte
 	lock       sync.Mutex
 	Size       int64
+	Mode       fs.FileMode
 	Lastaccess time.Time
 	Modified   time.Time
+	etag       string
+	variants   map[string]*variant
+	watched    bool
 }
 
 func (itm *cacheItem) WasModified(fi os.FileInfo) bool {
@@ -71,6 +82,16 @@ func (itm *cacheItem) Access() []byte {
 	return itm.content
 }
 
+// footprint returns the number of bytes the item takes up in the cache,
+// including any compressed variants.
+func (itm *cacheItem) footprint() int64 {
+	size := itm.Size
+	for _, v := range itm.variants {
+		size += int64(len(v.content))
+	}
+	return size
+}
+
 func (itm *cacheItem) Dur() time.Duration {
 	itm.lock.Lock()
 	defer itm.lock.Unlock()
@@ -81,31 +102,79 @@ func (itm *cacheItem) Dur() time.Duration {
 // An ExpireItem value of 0 means that items should not be expired based
 // on time in memory.
 type FileCache struct {
+	stats      counters // accessed atomically; first for 64-bit alignment
 	dur        time.Duration
-	items      map[string]*cacheItem
+	fsys       fs.FS
+	root       string       // directory OS file names are relative to, if set
+	shards     atomic.Value // []*shard, nil while the cache is stopped
 	in         chan string
 	mutex      sync.Mutex
+	watcher    watcher
 	shutdown   chan interface{}
 	wait       sync.WaitGroup
-	MaxItems   int   // Maximum number of files to cache
-	MaxSize    int64 // Maximum file size to store
-	ExpireItem int   // Seconds a file should be cached for
-	Every      int   // Run an expiration check Every seconds
+	loads      flightGroup
+	loading    loadTracker
+	hooks      hooks
+	MaxItems   int                   // Maximum number of files to cache
+	MaxSize    int64                 // Maximum file size to store
+	MaxBytes   int64                 // Maximum total size of stored files (0 is unlimited)
+	ExpireItem int                   // Seconds a file should be cached for
+	Every      int                   // Run an expiration check Every seconds
+	Eviction   func() EvictionPolicy // Eviction policy constructor (LRU if nil)
+	Admission  AdmissionPolicy       // Filter for files cached by reads (nil admits all)
+	Watch      bool                  // Watch OS files for changes instead of stat'ing them
+	Shards     int                   // Number of independently locked parts of the cache
+	Workers    int                   // Number of goroutines caching files in the background
+	QueueFull  QueuePolicy           // What Cache does when its queue is full
+
+	// Compressed variants, sent to clients that accept them
+	Compress      bool // Store a gzipped variant of each file
+	Precompressed bool // Store .br, .zst and .gz files found beside each file
 }
 
 // NewDefaultCache returns a new FileCache with sane defaults.
 func NewDefaultCache() *FileCache {
 	return &FileCache{
 		dur:        time.Since(time.Now()),
-		items:      nil,
 		in:         nil,
 		MaxItems:   DefaultMaxItems,
 		MaxSize:    DefaultMaxSize,
+		MaxBytes:   DefaultMaxBytes,
 		ExpireItem: DefaultExpireItem,
 		Every:      DefaultEvery,
+		Shards:     DefaultShards,
+		Workers:    DefaultWorkers,
 	}
 }
 
+// NewFSCache returns a new FileCache with the same defaults as
+// NewDefaultCache, which reads files from fsys rather than the operating
+// system's file system. File names must be valid fs.FS paths: unrooted,
+// slash-separated and without any "." or ".." elements.
+func NewFSCache(fsys fs.FS) *FileCache {
+	cache := NewDefaultCache()
+	cache.fsys = fsys
+	return cache
+}
+
+// NewDirCache returns a new FileCache with the same defaults as
+// NewDefaultCache, which caches the operating system files beneath the
+// directory root. File names are slash-separated paths relative to root,
+// as for NewFSCache, but unlike a cache of os.DirFS(root), the cache can
+// watch files for changes, and a Handler can refuse symlinks that lead
+// outside root.
+func NewDirCache(root string) *FileCache {
+	cache := NewDefaultCache()
+	cache.root = filepath.Clean(root)
+	return cache
+}
+
+// slashNames returns true if the cache's file names are slash-separated
+// paths relative to a root, rather than operating system paths.
+func (cache *FileCache) slashNames() bool {
+	return cache.fsys != nil || cache.root != ""
+}
+
 func (cache *FileCache) lock() {
 	cache.mutex.Lock()
 }
@@ -115,40 +184,83 @@ func (cache *FileCache) unlock() {
 }
 
 func (cache *FileCache) isCacheNull() bool {
-	cache.lock()
-	defer cache.unlock()
-	return cache.items == nil
+	return cache.getShards() == nil
+}
+
+// getShards returns the cache's shards, or nil if the cache isn't running.
+func (cache *FileCache) getShards() []*shard {
+	shards, _ := cache.shards.Load().([]*shard)
+	return shards
+}
+
+// shard returns the shard holding name, or nil if the cache isn't running.
+func (cache *FileCache) shard(name string) *shard {
+	shards := cache.getShards()
+	if shards == nil {
+		return nil
+	}
+	return shards[shardHash(name)%uint32(len(shards))]
 }
 
 func (cache *FileCache) getItem(name string) (itm *cacheItem, ok bool) {
-	if cache.isCacheNull() {
-		return nil, false
+	if s := cache.shard(name); s != nil {
+		itm, ok = s.get(name)
+	}
+	return
+}
+
+// accessItem is like getItem, but records the access with the eviction
+// policy. It should be used whenever an item's contents are handed out.
+func (cache *FileCache) accessItem(name string) (itm *cacheItem, ok bool) {
+	cache.record(name)
+	if s := cache.shard(name); s != nil {
+		itm, ok = s.access(name)
+	}
+	if ok {
+		cache.stats.add(&cache.stats.hits, 1)
+		cache.hooks.call(&cache.hooks.hit, name)
+	} else {
+		cache.stats.add(&cache.stats.misses, 1)
+		cache.hooks.call(&cache.hooks.miss, name)
 	}
-	cache.lock()
-	defer cache.unlock()
-	itm, ok = cache.items[name]
 	return
 }
 
-// addItem is an internal function for adding an item to the cache.
-func (cache *FileCache) addItem(name string) (err error) {
+// record notes a request for name with the admission policy, if any.
+func (cache *FileCache) record(name string) {
+	if cache.Admission != nil {
+		cache.Admission.Record(name)
+	}
+}
+
+// miss notes a request for name that wasn't found in the cache.
+func (cache *FileCache) miss(name string) {
+	cache.record(name)
+	cache.stats.add(&cache.stats.misses, 1)
+	cache.hooks.call(&cache.hooks.miss, name)
+}
+
+// served notes that n bytes of a cached file have been handed out.
+func (cache *FileCache) served(n int) {
+	cache.stats.add(&cache.stats.servedBytes, n)
+}
+
+// addItem is an internal function for adding an item to the cache. If
+// filter is true, the admission policy is consulted before evicting
+// anything to make room for the item.
+func (cache *FileCache) addItem(name string, filter bool) (err error) {
 	if cache.isCacheNull() {
 		return
 	}
 	ok := cache.InCache(name)
-	expired := cache.itemExpired(name)
+	reason, expired := cache.itemExpired(name)
 	if ok && !expired {
 		return nil
 	} else if ok {
-		delete(cache.items, name)
+		cache.deleteItem(name, reason)
 	}
 
-	itm, err := cacheFile(name, cache.MaxSize)
-	if cache.items != nil && itm != nil {
-		cache.lock()
-		cache.items[name] = itm
-		cache.unlock()
-	} else {
+	if _, err = cache.load(name, filter); err != nil {
 		return
 	}
 	if !cache.InCache(name) {
@@ -157,78 +269,308 @@ func (cache *FileCache) addItem(name string) (err error) {
 	return nil
 }
 
-func (cache *FileCache) deleteItem(name string) {
-	_, ok := cache.getItem(name)
-	if ok {
-		cache.lock()
-		delete(cache.items, name)
-		cache.unlock()
+// load reads the file named by 'name' and adds it to the cache. Concurrent
+// loads of the same file with the same filter are coalesced: the file is
+// read once, and all of the callers share the item and error from the
+// first caller's load. The item is returned even if it couldn't be added
+// to the cache, for example because it wasn't admitted, so that callers
+// can still use its contents; files larger than MaxSize aren't read, and
+// ItemTooLarge is returned.
+func (cache *FileCache) load(name string, filter bool) (*cacheItem, error) {
+	return cache.loads.do(loadKey(name, filter), func() (*cacheItem, error) {
+		return cache.loadItem(name, filter)
+	})
+}
+
+// loadKey returns the key under which loads of name are coalesced.
+func loadKey(name string, filter bool) string {
+	if !filter {
+		// Loads that must not be refused by the admission policy
+		// can't share the result of one that was.
+		return "\x00" + name
+	}
+	return name
+}
+
+// loadItem reads the file named by 'name' and adds it to the cache, if
+// the cache is running.
+func (cache *FileCache) loadItem(name string, filter bool) (itm *cacheItem, err error) {
+	active := !cache.isCacheNull()
+
+	// Start watching before the file is read, and check for changes
+	// reported while it was being cached once it has been stored, so that
+	// a change made while it is being cached isn't missed.
+	gen := cache.loading.begin(name)
+	watched := active && cache.watch(name)
+	start := time.Now()
+	itm, err = cache.cacheFile(name)
+	cache.stats.add(&cache.stats.loadTime, int(time.Since(start)))
+	switch {
+	case itm != nil:
+		cache.stats.add(&cache.stats.loads, 1)
+	case err != ItemTooLarge && err != ItemIsDirectory:
+		cache.stats.add(&cache.stats.loadErrors, 1)
+	}
+	if itm != nil && active {
+		err = cache.makeRoom(name, itm.footprint(), filter)
+	}
+	inserted := false
+	if itm != nil && err == nil {
+		itm.watched = watched
+		inserted = cache.insertItem(name, itm)
+	}
+	if watched && !inserted {
+		cache.unwatch(name)
+	}
+	if inserted {
+		cache.hooks.call(&cache.hooks.insert, name)
+	} else if err != nil && err != ItemIsDirectory && err != ItemNotAdmitted {
+		cache.hooks.onLoadError(name, err)
+	}
+	if cache.loading.end(name, gen) && inserted {
+		cache.deleteItem(name, EvictModified)
+	}
+	return
+}
+
+// cacheFile reads the file named by 'name' into a new cache item.
+func (cache *FileCache) cacheFile(name string) (itm *cacheItem, err error) {
+	fi, err := cache.stat(name)
+	if err != nil {
+		return
+	} else if fi.Mode().IsDir() {
+		return nil, ItemIsDirectory
+	} else if fi.Size() > cache.MaxSize {
+		return nil, ItemTooLarge
+	}
+
+	content, err := cache.readFile(name)
+	if err != nil {
+		return
+	}
+
+	itm = &cacheItem{
+		content:    content,
+		Size:       fi.Size(),
+		Mode:       fi.Mode(),
+		Modified:   fi.ModTime(),
+		Lastaccess: time.Now(),
+		etag:       contentTag(content),
+		variants:   cache.loadVariants(name, content),
+	}
+	return
+}
+
+// stat returns the FileInfo for the file named by 'name' from the cache's
+// file system.
+func (cache *FileCache) stat(name string) (fs.FileInfo, error) {
+	if cache.fsys != nil {
+		return fs.Stat(cache.fsys, name)
+	}
+	return os.Stat(cache.osPath(name))
+}
+
+// open opens the file named by 'name' from the cache's file system.
+func (cache *FileCache) open(name string) (fs.File, error) {
+	if cache.fsys != nil {
+		return cache.fsys.Open(name)
+	}
+	return os.Open(cache.osPath(name))
+}
+
+// osPath returns the operating system path of the file named by 'name',
+// for caches of operating system files.
+func (cache *FileCache) osPath(name string) string {
+	if cache.root == "" {
+		return name
+	}
+	return filepath.Join(cache.root, filepath.FromSlash(name))
+}
+
+// osName returns the name of the file at the operating system path p; it
+// is the inverse of osPath.
+func (cache *FileCache) osName(p string) string {
+	if cache.root == "" {
+		return p
+	}
+	rel, err := filepath.Rel(cache.root, p)
+	if err != nil {
+		return p
+	}
+	return filepath.ToSlash(rel)
+}
+
+// serveFile serves the file named by 'name' from the cache's file system
+// without going through the cache.
+func (cache *FileCache) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	if cache.fsys == nil {
+		http.ServeFile(w, r, cache.osPath(name))
+		return
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = path.Join("/", name)
+	http.FileServer(http.FS(cache.fsys)).ServeHTTP(w, r2)
+}
+
+// insertItem stores itm in the cache under name, returning false if the
+// cache isn't running.
+func (cache *FileCache) insertItem(name string, itm *cacheItem) bool {
+	s := cache.shard(name)
+	if s == nil {
+		return false
+	}
+	s.insert(name, itm)
+	return true
+}
+
+// deleteItem removes name from the cache and the eviction policy, for the
+// given reason.
+func (cache *FileCache) deleteItem(name string, reason EvictReason) {
+	s := cache.shard(name)
+	if s == nil {
+		return
+	}
+	itm, ok := s.remove(name)
+	if !ok {
+		return
+	}
+	cache.stats.add(&cache.stats.evictions[reason], 1)
+	if itm.watched {
+		cache.unwatch(name)
+	}
+	cache.hooks.onEvict(name, reason)
+}
+
+// invalidate removes name from the cache because the file has changed.
+func (cache *FileCache) invalidate(name string) {
+	cache.loading.invalidate(name)
+	cache.deleteItem(name, EvictModified)
+}
+
+// invalidatePath is invalidate for the file at the operating system path
+// p, as reported by the watcher.
+func (cache *FileCache) invalidatePath(p string) {
+	cache.invalidate(cache.osName(p))
+}
+
+// watch starts watching name for changes if the cache is in watch mode.
+// It returns true if the cache will be told when the file changes.
+func (cache *FileCache) watch(name string) bool {
+	cache.lock()
+	w := cache.watcher
+	cache.unlock()
+	return w != nil && w.Add(cache.osPath(name)) == nil
+}
+
+func (cache *FileCache) unwatch(name string) {
+	cache.lock()
+	w := cache.watcher
+	cache.unlock()
+	if w != nil {
+		w.Remove(cache.osPath(name))
 	}
 }
 
 // itemListener is a goroutine that listens for incoming files and caches
-// them.
-func (cache *FileCache) itemListener() {
-	cache.wait.Add(1)
+// them. It is given the channels it uses, rather than reading them from
+// the cache, as Start and Stop replace them.
+func (cache *FileCache) itemListener(in <-chan string, shutdown <-chan interface{}) {
+	defer cache.wait.Done()
 	for {
 		select {
-		case name := <-cache.in:
-			cache.addItem(name)
-		case <-cache.shutdown:
-			cache.wait.Done()
+		case name := <-in:
+			// Files that can't be cached are reported to the
+			// OnLoadError hooks by loadItem.
+			cache.addItem(name, true)
+		case <-shutdown:
 			return
 		}
 	}
 }
 
-// expireOldest is used to expire the oldest item in the cache.
-// The force argument is used to indicate it should remove at least one
-// entry; for example, if a large number of files are cached at once, none
-// may appear older than another.
-func (cache *FileCache) expireOldest(force bool) {
-	oldest := time.Now()
-	oldestName := ""
+// overCapacity returns true if adding the given number of items and bytes
+// would put the cache over its MaxItems or MaxBytes limits.
+func (cache *FileCache) overCapacity(items int, size int64) bool {
+	if cache.Size()+items > cache.MaxItems {
+		return true
+	}
+	return cache.MaxBytes > 0 && cache.FileSize()+size > cache.MaxBytes
+}
 
-	for name, itm := range cache.items {
-		if force && oldestName == "" {
-			oldest = itm.Lastaccess
-			oldestName = name
-		} else if itm.Lastaccess.Before(oldest) {
-			oldest = itm.Lastaccess
-			oldestName = name
+// makeRoom evicts items until an item of the given size fits in the cache.
+// If the item could never fit, ItemTooLarge is returned. If filter is true
+// and the cache is full, the admission policy decides whether the item is
+// worth evicting the current victim; if not, ItemNotAdmitted is returned.
+func (cache *FileCache) makeRoom(name string, size int64, filter bool) error {
+	if cache.MaxBytes > 0 && size > cache.MaxBytes {
+		return ItemTooLarge
+	}
+	if filter && cache.Admission != nil && cache.overCapacity(1, size) {
+		victim, ok := cache.victim()
+		if ok && !cache.Admission.Admit(name, victim) {
+			return ItemNotAdmitted
 		}
 	}
-	if oldestName != "" {
-		cache.deleteItem(oldestName)
+	for cache.overCapacity(1, size) {
+		if !cache.evict() {
+			break
+		}
 	}
+	return nil
 }
 
-// vacuum is a background goroutine responsible for cleaning the cache.
-// It runs periodically, every cache.Every seconds. If cache.Every is set
-// to 0, it will not run.
-func (cache *FileCache) vacuum() {
-	if cache.Every < 1 {
+// victim returns the name the eviction policy would evict next. Limits
+// apply to the cache as a whole, so if the cache is sharded, the victim is
+// chosen by the policy of the shard holding the most items.
+func (cache *FileCache) victim() (name string, ok bool) {
+	var fullest *shard
+	most := 0
+	for _, s := range cache.getShards() {
+		if n, _ := s.size(); n > most {
+			fullest, most = s, n
+		}
+	}
+	if fullest == nil {
 		return
 	}
+	return fullest.victim()
+}
+
+// evict removes the item chosen by the eviction policy from the cache. It
+// returns false if the policy had nothing to evict.
+func (cache *FileCache) evict() bool {
+	name, ok := cache.victim()
+	if ok {
+		cache.deleteItem(name, EvictCapacity)
+	}
+	return ok
+}
 
-	cache.wait.Add(1)
+// vacuum is a background goroutine responsible for cleaning the cache.
+// It runs periodically, every dur (cache.Every seconds). If cache.Every is
+// set to 0, it is not started.
+func (cache *FileCache) vacuum(dur time.Duration, shutdown <-chan interface{}) {
+	defer cache.wait.Done()
 	for {
 		select {
-		case _ = <-cache.shutdown:
-			cache.wait.Done()
+		case _ = <-shutdown:
 			return
-		case <-time.After(cache.dur):
+		case <-time.After(dur):
 			if cache.isCacheNull() {
-				cache.wait.Done()
 				return
 			}
-			for name, _ := range cache.items {
-				if cache.itemExpired(name) {
-					cache.deleteItem(name)
+			for _, name := range cache.StoredFiles() {
+				if reason, expired := cache.itemExpired(name); expired {
+					cache.deleteItem(name, reason)
 				}
 			}
-			for size := cache.Size(); size > cache.MaxItems; size = cache.Size() {
-				cache.expireOldest(true)
+			for cache.overCapacity(0, 0) {
+				if !cache.evict() {
+					break
+				}
 			}
 		}
 	}
@@ -236,13 +578,16 @@ func (cache *FileCache) vacuum() {
 
 // FileChanged returns true if file should be expired based on mtime.
 // If the file has changed on disk or no longer exists, it should be
-// expired.
+// expired. Watched files are removed from the cache as soon as they
+// change, so they don't need to be checked.
 func (cache *FileCache) changed(name string) bool {
 	itm, ok := cache.getItem(name)
 	if !ok || itm == nil {
 		return true
+	} else if itm.watched {
+		return false
 	}
-	fi, err := os.Stat(name)
+	fi, err := cache.stat(name)
 	if err != nil {
 		return true
 	} else if !itm.WasModified(fi) {
@@ -267,37 +612,42 @@ func (cache *FileCache) expired(name string) bool {
 	return false
 }
 
-// itemExpired returns true if an item is expired.
-func (cache *FileCache) itemExpired(name string) bool {
+// itemExpired returns true if an item is expired, and why.
+func (cache *FileCache) itemExpired(name string) (EvictReason, bool) {
 	if cache.changed(name) {
-		return true
+		return EvictModified, true
 	} else if cache.ExpireItem != 0 && cache.expired(name) {
-		return true
+		return EvictTTL, true
 	}
-	return false
+	return 0, false
 }
 
 // Active returns true if the cache has been started, and false otherwise.
 func (cache *FileCache) Active() bool {
-	if cache.in == nil || cache.isCacheNull() {
+	cache.lock()
+	in := cache.in
+	cache.unlock()
+	if in == nil || cache.isCacheNull() {
 		return false
 	}
 	return true
 }
 
 // Size returns the number of entries in the cache.
-func (cache *FileCache) Size() int {
-	cache.lock()
-	defer cache.unlock()
-	return len(cache.items)
+func (cache *FileCache) Size() (items int) {
+	for _, s := range cache.getShards() {
+		n, _ := s.size()
+		items += n
+	}
+	return
 }
 
-// FileSize returns the sum of the file sizes stored in the cache
+// FileSize returns the sum of the file sizes stored in the cache,
+// including any compressed variants.
 func (cache *FileCache) FileSize() (totalSize int64) {
-	cache.lock()
-	defer cache.unlock()
-	for _, itm := range cache.items {
-		totalSize += itm.Size
+	for _, s := range cache.getShards() {
+		_, size := s.size()
+		totalSize += size
 	}
 	return
 }
@@ -305,31 +655,26 @@ func (cache *FileCache) FileSize() (totalSize int64) {
 // StoredFiles returns the list of files stored in the cache.
 func (cache *FileCache) StoredFiles() (fileList []string) {
 	fileList = make([]string, 0, cache.Size())
-	if cache.isCacheNull() || cap(fileList) == 0 {
-		return
-	}
-
-	cache.lock()
-	defer cache.unlock()
-	for name, _ := range cache.items {
-		fileList = append(fileList, name)
+	for _, s := range cache.getShards() {
+		fileList = s.names(fileList)
 	}
 	return
 }
 
 // InCache returns true if the item is in the cache.
 func (cache *FileCache) InCache(name string) bool {
-	if cache.changed(name) {
-		cache.deleteItem(name)
+	if _, ok := cache.getItem(name); !ok {
+		return false
+	} else if cache.changed(name) {
+		cache.invalidate(name)
 		return false
 	}
-	_, ok := cache.items[name]
-	return ok
+	return true
 }
 
 // WriteItem writes the cache item to the specified io.Writer.
 func (cache *FileCache) WriteItem(w io.Writer, name string) (err error) {
-	itm, ok := cache.getItem(name)
+	itm, ok := cache.accessItem(name)
 	if !ok {
 		if !SquelchItemNotInCache {
 			err = ItemNotInCache
@@ -337,8 +682,8 @@ func (cache *FileCache) WriteItem(w io.Writer, name string) (err error) {
 		return
 	}
 	r := itm.GetReader()
-	itm.Lastaccess = time.Now()
 	n, err := io.Copy(w, r)
+	cache.served(int(n))
 	if err != nil {
 		return
 	} else if int64(n) != itm.Size {
@@ -352,21 +697,23 @@ func (cache *FileCache) WriteItem(w io.Writer, name string) (err error) {
 // GetItem should be used when you are certain an object is in the cache,
 // or if you want to use the cache only.
 func (cache *FileCache) GetItem(name string) (content []byte, ok bool) {
-	itm, ok := cache.getItem(name)
+	itm, ok := cache.accessItem(name)
 	if !ok {
 		return
 	}
 	content = itm.Access()
+	cache.served(len(content))
 	return
 }
 
 // GetItemString is the same as GetItem, except returning a string.
 func (cache *FileCache) GetItemString(name string) (content string, ok bool) {
-	itm, ok := cache.getItem(name)
+	itm, ok := cache.accessItem(name)
 	if !ok {
 		return
 	}
 	content = string(itm.Access())
+	cache.served(len(content))
 	return
 }
 
@@ -380,60 +727,64 @@ func (cache *FileCache) ReadFileString(name string) (content string, err error)
 }
 
 // WriteFile writes the file named by 'name' to the specified io.Writer.
-// If the file is in the cache, it is loaded from the cache; otherwise,
-// it is read from the filesystem and the file is cached in the background.
+// If the file is in the cache, it is loaded from the cache; otherwise, it
+// is read from the filesystem and cached first, sharing the read with any
+// concurrent requests for the same file. Files too large to cache are
+// copied straight from the filesystem.
 func (cache *FileCache) WriteFile(w io.Writer, name string) (err error) {
+	var itm *cacheItem
 	if cache.InCache(name) {
-		err = cache.WriteItem(w, name)
+		itm, _ = cache.accessItem(name)
 	} else {
-		var fi os.FileInfo
-		fi, err = os.Stat(name)
-		if err != nil {
-			return
-		} else if fi.IsDir() {
-			return ItemIsDirectory
-		}
-		go cache.Cache(name)
-		var file *os.File
-		file, err = os.Open(name)
-		if err != nil {
-			return
-		}
-		defer file.Close()
-		_, err = io.Copy(w, file)
-
+		cache.miss(name)
+	}
+	if itm == nil {
+		itm, err = cache.load(name, true)
+	}
+	switch {
+	case itm != nil:
+		var n int
+		n, err = w.Write(itm.Access())
+		cache.served(n)
+	case err == ItemTooLarge:
+		err = cache.copyFile(context.Background(), w, name)
 	}
 	return
 }
 
+// HttpWriteFile serves the file named by the request's URL path. Cached
+// files are sent with ETag and Last-Modified headers, and are served with
+// http.ServeContent, so conditional and range requests are handled just
+// as they are for files served from the file system. If the cache holds
+// compressed variants of a file, the client's preferred encoding is sent.
+// Files not in the cache are cached before they are served, with
+// concurrent requests for the same file sharing a single read; files too
+// large to cache are served from the file system. Only GET and HEAD
+// requests are served; OPTIONS requests are answered with an Allow
+// header, and other methods are refused with 405 Method Not Allowed.
+//
+// The request path is used as the file name as it is, so HttpWriteFile
+// should only be used with trusted clients; HttpHandlerRoot returns a
+// handler that restricts requests to a single directory.
 func (cache *FileCache) HttpWriteFile(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, httpError) {
+		return
+	}
 	path, err := url.QueryUnescape(r.URL.String())
 	if err != nil {
-		http.ServeFile(w, r, r.URL.Path)
+		cache.serveFile(w, r, r.URL.Path)
 	} else if len(path) > 1 {
 		path = path[1:len(path)]
 	} else {
-		http.ServeFile(w, r, ".")
+		cache.serveFile(w, r, ".")
 		return
 	}
 
-	if cache.InCache(path) {
-		itm := cache.items[path]
-		ctype := http.DetectContentType(itm.Access())
-		mtype := mime.TypeByExtension(filepath.Ext(path))
-		if mtype != "" && mtype != ctype {
-			ctype = mtype
-		}
-		header := w.Header()
-		header.Set("content-length", fmt.Sprintf("%d", itm.Size))
-		header.Set("content-disposition",
-			fmt.Sprintf("filename=%s", filepath.Base(path)))
-		header.Set("content-type", ctype)
-		w.Write(itm.Access())
-		return
+	// Files that can't be cached, such as directories and files larger
+	// than MaxSize, are served from the file system.
+	if !cache.serveCached(w, r, path) {
+		cache.serveFile(w, r, path)
 	}
-	go cache.Cache(path)
-	http.ServeFile(w, r, path)
 }
 
 // HttpHandler returns a valid HTTP handler for the given cache.
@@ -446,40 +797,66 @@ func HttpHandler(cache *FileCache) func(http.ResponseWriter, *http.Request) {
 // Cache will store the file named by 'name' to the cache.
 // This function doesn't return anything as it passes the file onto the
 // incoming pipe; the file will be cached asynchronously. Errors will
-// not be returned.
+// not be returned. If the cache has an admission policy, the file is only
+// cached if the policy admits it. If the queue of files to cache is full,
+// the QueueFull policy decides whether Cache waits for room, which
+// CacheContext can limit, or drops a file. If the cache is stopped while
+// the file is waiting to be queued, it is dropped.
 func (cache *FileCache) Cache(name string) {
-	if cache.Size() == cache.MaxItems {
-		cache.expireOldest(true)
-	}
-	cache.in <- name
+	cache.CacheContext(context.Background(), name)
 }
 
-// CacheNow immediately caches the file named by 'name'.
+// CacheNow immediately caches the file named by 'name'. The admission
+// policy is not consulted.
 func (cache *FileCache) CacheNow(name string) (err error) {
-	if cache.Size() == cache.MaxItems {
-		cache.expireOldest(true)
-	}
-	return cache.addItem(name)
+	return cache.addItem(name, false)
 }
 
 // Start activates the file cache; it will start up the background caching
 // and automatic cache expiration goroutines and initialise the internal
 // data structures.
 func (cache *FileCache) Start() error {
-	if cache.in != nil {
-		close(cache.in)
-		close(cache.shutdown)
-	}
 	dur, err := time.ParseDuration(fmt.Sprintf("%ds", cache.Every))
 	if err != nil {
 		return err
 	}
+	n := cache.Shards
+	if n < 1 {
+		n = 1
+	}
+	shards := make([]*shard, n)
+	for i := range shards {
+		if cache.Eviction != nil {
+			shards[i] = newShard(cache.Eviction())
+		} else {
+			shards[i] = newShard(NewLRUPolicy())
+		}
+	}
+	cache.shards.Store(shards)
+
+	cache.lock()
+	defer cache.unlock()
+	if cache.Watch && cache.fsys == nil && cache.watcher == nil {
+		cache.watcher = newWatcher(cache.invalidatePath)
+	}
+	if cache.in != nil {
+		close(cache.shutdown)
+	}
 	cache.dur = dur
-	cache.items = make(map[string]*cacheItem, 0)
 	cache.in = make(chan string, NewCachePipeSize)
 	cache.shutdown = make(chan interface{}, 1)
-	go cache.itemListener()
-	go cache.vacuum()
+	workers := cache.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		cache.wait.Add(1)
+		go cache.itemListener(cache.in, cache.shutdown)
+	}
+	if cache.Every > 0 {
+		cache.wait.Add(1)
+		go cache.vacuum(dur, cache.shutdown)
+	}
 	return nil
 }
 
@@ -489,21 +866,31 @@ func (cache *FileCache) Start() error {
 // If there are any items or cache operations ongoing while Stop() is called,
 // it is undefined how they will behave.
 func (cache *FileCache) Stop() {
-	if cache.in != nil {
-		close(cache.in)
+	cache.lock()
+	running := cache.in != nil
+	if running {
 		close(cache.shutdown)
+		cache.in = nil
+	}
+	cache.unlock()
+	if running {
 		<-time.After(1 * time.Microsecond) // give goroutines time to shutdown
 	}
 
-	if cache.items != nil {
+	if !cache.isCacheNull() {
 		items := cache.StoredFiles()
 		for _, name := range items {
-			cache.deleteItem(name)
+			cache.deleteItem(name, EvictManual)
 		}
-		cache.lock()
-		cache.items = nil
-		cache.unlock()
+		cache.shards.Store([]*shard(nil))
+	}
+
+	cache.lock()
+	if cache.watcher != nil {
+		cache.watcher.Close()
+		cache.watcher = nil
 	}
+	cache.unlock()
 	cache.wait.Wait()
 }
 
@@ -511,11 +898,11 @@ func (cache *FileCache) Stop() {
 // It returns a boolean indicating whether anything was removed, and an error
 // if an error has occurred.
 func (cache *FileCache) Remove(name string) (ok bool, err error) {
-	_, ok = cache.items[name]
+	_, ok = cache.getItem(name)
 	if !ok {
 		return
 	}
-	cache.deleteItem(name)
+	cache.deleteItem(name, EvictManual)
 	_, valid := cache.getItem(name)
 	if valid {
 		ok = false
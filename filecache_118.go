@@ -3,49 +3,61 @@
 package filecache
 
 import (
+	"io/fs"
 	"os"
-	"time"
 )
 
-func cacheFile(path string, maxSize int64) (itm *cacheItem, err error) {
-	fi, err := os.Stat(path)
-	if err != nil {
-		return
-	} else if fi.Mode().IsDir() {
-		return nil, ItemIsDirectory
-	} else if fi.Size() > maxSize {
-		return nil, ItemTooLarge
-	}
-
-	content, err := os.ReadFile(path)
-	if err != nil {
-		return
-	}
-
-	itm = &cacheItem{
-		content:    content,
-		Size:       fi.Size(),
-		Modified:   fi.ModTime(),
-		Lastaccess: time.Now(),
+// readFile reads the file named by 'name' from the cache's file system.
+func (cache *FileCache) readFile(name string) (content []byte, err error) {
+	if cache.fsys != nil {
+		content, err = fs.ReadFile(cache.fsys, name)
+	} else {
+		content, err = os.ReadFile(cache.osPath(name))
 	}
+	cache.stats.add(&cache.stats.diskBytes, len(content))
 	return
 }
 
 // ReadFile retrieves the file named by 'name'.
-// If the file is not in the cache, load the file and cache the file in the
-// background. If the file was not in the cache and the read was successful,
-// the error ItemNotInCache is returned to indicate that the item was pulled
-// from the filesystem and not the cache, unless the SquelchItemNotInCache
-// global option is set; in that case, returns no error.
+// If the file is not in the cache, it is read and cached before ReadFile
+// returns; concurrent calls for the same file share a single read. Files
+// too large to cache are read without being cached. If the file was not
+// in the cache and the read was successful, the error ItemNotInCache is
+// returned to indicate that the item was pulled from the filesystem and
+// not the cache, unless the SquelchItemNotInCache global option is set;
+// in that case, returns no error.
+//
+// The returned slice is a copy of the cached contents that the caller may
+// modify, as required by fs.ReadFileFS; GetItem avoids the copy. Unlike
+// the other fs.FS methods, ReadFile accepts any operating system path if
+// the cache was created by NewDefaultCache; for a cache created by
+// NewDirCache, the path is relative to the cache's directory.
 func (cache *FileCache) ReadFile(name string) (content []byte, err error) {
 	if cache.InCache(name) {
-		content, _ = cache.GetItem(name)
+		if itm, ok := cache.accessItem(name); ok {
+			content = append([]byte(nil), itm.Access()...)
+			cache.served(len(content))
+			return
+		}
 	} else {
-		go cache.Cache(name)
-		content, err = os.ReadFile(name)
-		if err == nil && !SquelchItemNotInCache {
-			err = ItemNotInCache
+		cache.miss(name)
+	}
+
+	itm, err := cache.load(name, true)
+	switch {
+	case itm != nil:
+		content = append([]byte(nil), itm.content...)
+		cache.served(len(content))
+	case err == ItemTooLarge:
+		if content, err = cache.readFile(name); err != nil {
+			return
 		}
+	default:
+		return
+	}
+	err = nil
+	if !SquelchItemNotInCache {
+		err = ItemNotInCache
 	}
 	return
 }
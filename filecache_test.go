@@ -1,10 +1,14 @@
 package filecache
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
@@ -20,7 +24,7 @@ func getTimeExpiredCacheItem() *cacheItem {
 }
 
 func (cache *FileCache) _add_cache_item(name string, itm *cacheItem) {
-	cache.items[name] = itm
+	cache.insertItem(name, itm)
 }
 
 func dumpModTime(name string) {
@@ -314,6 +318,56 @@ func TestExpireOldest(t *testing.T) {
 	destroyNames(names)
 }
 
+func TestMaxBytes(t *testing.T) {
+	fmt.Printf("[+] validating byte limit on cache: ")
+	cache := NewDefaultCache()
+	cache.MaxBytes = 64
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+	}
+
+	names := make([]string, 0)
+	for i := 0; i < 10; i++ {
+		name := writeTempFile(t, fmt.Sprintf("file number %d with padding\n", i))
+		if t.Failed() {
+			break
+		}
+		names = append(names, name)
+		if err := cache.CacheNow(name); err != nil {
+			fmt.Println("failed")
+			fmt.Println("[!] failed to cache item: ", err.Error())
+			t.Fail()
+			break
+		}
+	}
+
+	if !t.Failed() && cache.FileSize() > cache.MaxBytes {
+		fmt.Println("failed")
+		fmt.Printf("[!] %d bytes in cache (limit should be %d)\n",
+			cache.FileSize(), cache.MaxBytes)
+		t.Fail()
+	} else if !t.Failed() && !cache.InCache(names[len(names)-1]) {
+		fmt.Println("failed")
+		fmt.Println("[!] most recent item should be in the cache")
+		t.Fail()
+	}
+
+	large := writeTempFile(t, strings.Repeat("x", 128))
+	names = append(names, large)
+	if !t.Failed() {
+		if err := cache.CacheNow(large); err != ItemTooLarge {
+			fmt.Println("failed")
+			fmt.Println("[!] item larger than MaxBytes should be rejected")
+			t.Fail()
+		} else {
+			fmt.Println("ok")
+		}
+	}
+	cache.Stop()
+	destroyNames(names)
+}
+
 func TestNeverExpire(t *testing.T) {
 	fmt.Printf("[+] validating no time limit expirations: ")
 	cache := NewDefaultCache()
@@ -441,3 +495,125 @@ func ValidateDataMatchesFile(out []byte, filename string) bool {
 	}
 	return true
 }
+
+func fillPolicy(policy EvictionPolicy, n int) []string {
+	names := make([]string, n)
+	for i := 0; i < n; i++ {
+		names[i] = fmt.Sprintf("file%d", i)
+		policy.Insert(names[i])
+	}
+	return names
+}
+
+func BenchmarkLRUAccess(b *testing.B) {
+	policy := NewLRUPolicy()
+	names := fillPolicy(policy, 4096)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		policy.Access(names[i%len(names)])
+	}
+}
+
+func BenchmarkLRUEviction(b *testing.B) {
+	policy := NewLRUPolicy()
+	fillPolicy(policy, 4096)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		victim, _ := policy.Victim()
+		policy.Remove(victim)
+		policy.Insert(victim)
+	}
+}
+
+func BenchmarkCacheEviction(b *testing.B) {
+	cache := NewDefaultCache()
+	cache.MaxItems = 4096
+	if err := cache.Start(); err != nil {
+		fmt.Println("[!] cache failed to start: ", err.Error())
+	}
+	for i := 0; i < cache.MaxItems; i++ {
+		name := fmt.Sprintf("file%d", i)
+		cache.insertItem(name, &cacheItem{})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.evict()
+		name := fmt.Sprintf("new%d", i)
+		cache.insertItem(name, &cacheItem{})
+	}
+	b.StopTimer()
+	cache.Stop()
+}
+
+func TestFSCache(t *testing.T) {
+	fmt.Printf("[+] testing cache backed by an fs.FS: ")
+	fsys := fstest.MapFS{
+		"hello.txt":      {Data: []byte("hello, world"), ModTime: time.Now()},
+		"static/app.css": {Data: []byte("body { margin: 0; }")},
+	}
+	cache := NewFSCache(fsys)
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+	}
+	defer cache.Stop()
+
+	if err := cache.CacheNow("hello.txt"); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] failed to cache item: ", err.Error())
+		t.FailNow()
+	} else if content, ok := cache.GetItemString("hello.txt"); !ok || content != "hello, world" {
+		fmt.Println("failed")
+		fmt.Println("[!] cached contents do not match the file system")
+		t.FailNow()
+	} else if err = cache.CacheNow("static"); err != ItemIsDirectory {
+		fmt.Println("failed")
+		fmt.Println("[!] directories should not be cached")
+		t.FailNow()
+	}
+
+	fsys["hello.txt"] = &fstest.MapFile{
+		Data:    []byte("goodbye"),
+		ModTime: time.Now().Add(time.Second),
+	}
+	if cache.InCache("hello.txt") {
+		fmt.Println("failed")
+		fmt.Println("[!] modified file should have been expired")
+		t.FailNow()
+	}
+
+	buf := new(bytes.Buffer)
+	if err := cache.WriteFile(buf, "static/app.css"); err != nil || buf.String() != "body { margin: 0; }" {
+		fmt.Println("failed")
+		fmt.Println("[!] WriteFile did not read from the file system")
+		t.FailNow()
+	}
+
+	w := httptest.NewRecorder()
+	cache.HttpWriteFile(w, httptest.NewRequest("GET", "/hello.txt", nil))
+	if w.Code != 200 || w.Body.String() != "goodbye" {
+		fmt.Println("failed")
+		fmt.Printf("[!] HTTP request returned %d: %s\n", w.Code, w.Body.String())
+		t.FailNow()
+	}
+
+	// The root directory is served by the file system, not redirected
+	// back to itself.
+	w = httptest.NewRecorder()
+	cache.HttpWriteFile(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "hello.txt") {
+		fmt.Println("failed")
+		fmt.Printf("[!] request for / returned %d (location %q)\n", w.Code, w.Header().Get("location"))
+		t.FailNow()
+	}
+
+	// Both misses are cached as they are read.
+	for _, name := range []string{"hello.txt", "static/app.css"} {
+		if !cache.InCache(name) {
+			fmt.Println("failed")
+			fmt.Printf("[!] %s should have been cached when it was read\n", name)
+			t.FailNow()
+		}
+	}
+	fmt.Println("ok")
+}
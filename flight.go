@@ -0,0 +1,129 @@
+package filecache
+
+import (
+	"context"
+	"sync"
+)
+
+// flight is a load of a file that is in progress or has completed. done
+// is closed once itm and err are set.
+type flight struct {
+	done chan struct{}
+	itm  *cacheItem
+	err  error
+}
+
+// flightGroup coalesces concurrent loads of the same file, so that a file
+// requested by many clients at once is only read once.
+type flightGroup struct {
+	mutex   sync.Mutex
+	flights map[string]*flight
+}
+
+// join returns the load of name in progress, or starts a new one, in which
+// case leader is true and the caller must run it.
+func (g *flightGroup) join(name string) (f *flight, leader bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	if f, ok := g.flights[name]; ok {
+		return f, false
+	}
+	f = &flight{done: make(chan struct{})}
+	g.flights[name] = f
+	return f, true
+}
+
+// run calls load and hands its results to everyone waiting on f.
+func (g *flightGroup) run(name string, f *flight, load func() (*cacheItem, error)) {
+	f.itm, f.err = load()
+	g.mutex.Lock()
+	delete(g.flights, name)
+	g.mutex.Unlock()
+	close(f.done)
+}
+
+// do calls load for name and returns its results, unless a load of name
+// is already in progress, in which case it waits for that load and returns
+// its results instead.
+func (g *flightGroup) do(name string, load func() (*cacheItem, error)) (*cacheItem, error) {
+	f, leader := g.join(name)
+	if leader {
+		g.run(name, f, load)
+	}
+	<-f.done
+	return f.itm, f.err
+}
+
+// doContext is like do, but stops waiting and returns ctx.Err() once ctx
+// is done. The load itself carries on, so that other callers still get its
+// results.
+func (g *flightGroup) doContext(ctx context.Context, name string, load func() (*cacheItem, error)) (*cacheItem, error) {
+	f, leader := g.join(name)
+	if leader {
+		go g.run(name, f, load)
+	}
+	select {
+	case <-f.done:
+		return f.itm, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// loadTracker counts the invalidations of each file while it is being
+// loaded. A file can change after it has been read but before its item is
+// stored, in which case there is no item for invalidate to remove, so the
+// load has to check for itself once the item is stored.
+type loadTracker struct {
+	mutex sync.Mutex
+	files map[string]*loadState
+}
+
+// loadState is the number of loads of a file in progress, and the number
+// of times the file has been invalidated since the first of them started.
+type loadState struct {
+	loads       int
+	generations uint64
+}
+
+// begin notes the start of a load of name, returning the generation to
+// pass to end.
+func (t *loadTracker) begin(name string) uint64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.files == nil {
+		t.files = make(map[string]*loadState)
+	}
+	st, ok := t.files[name]
+	if !ok {
+		st = new(loadState)
+		t.files[name] = st
+	}
+	st.loads++
+	return st.generations
+}
+
+// end notes the end of a load of name that began at generation gen,
+// returning true if name was invalidated while it was loading.
+func (t *loadTracker) end(name string, gen uint64) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	st := t.files[name]
+	st.loads--
+	if st.loads == 0 {
+		delete(t.files, name)
+	}
+	return st.generations != gen
+}
+
+// invalidate notes that name has changed, if it is being loaded.
+func (t *loadTracker) invalidate(name string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if st, ok := t.files[name]; ok {
+		st.generations++
+	}
+}
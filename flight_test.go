@@ -0,0 +1,96 @@
+package filecache
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// slowFS is a file system that counts the files read from it, and takes
+// delay to read each of them.
+type slowFS struct {
+	fstest.MapFS
+	delay time.Duration
+	reads int64
+}
+
+func (fsys *slowFS) ReadFile(name string) ([]byte, error) {
+	atomic.AddInt64(&fsys.reads, 1)
+	time.Sleep(fsys.delay)
+	return fs.ReadFile(fsys.MapFS, name)
+}
+
+func TestConcurrentLoads(t *testing.T) {
+	fmt.Printf("[+] testing concurrent loads of uncached files: ")
+	content := []byte("console.log('hello');")
+	fsys := &slowFS{delay: 20 * time.Millisecond, MapFS: fstest.MapFS{
+		"app.js": {Data: content},
+		"big.js": {Data: bytes.Repeat(content, 10)},
+	}}
+	cache := NewFSCache(fsys)
+	cache.MaxSize = 64
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+		t.FailNow()
+	}
+	defer cache.Stop()
+
+	var wg sync.WaitGroup
+	var failed int64
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var data []byte
+			switch i % 3 {
+			case 0:
+				data, _ = cache.ReadFile("app.js")
+			case 1:
+				buf := new(bytes.Buffer)
+				cache.WriteFile(buf, "app.js")
+				data = buf.Bytes()
+			case 2:
+				w := httptest.NewRecorder()
+				cache.HttpWriteFile(w, httptest.NewRequest("GET", "/app.js", nil))
+				data = w.Body.Bytes()
+			}
+			if !bytes.Equal(data, content) {
+				atomic.AddInt64(&failed, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if failed > 0 {
+		fmt.Println("failed")
+		fmt.Printf("[!] %d readers got the wrong contents\n", failed)
+		t.FailNow()
+	} else if reads := atomic.LoadInt64(&fsys.reads); reads != 1 {
+		fmt.Println("failed")
+		fmt.Printf("[!] file was read %d times\n", reads)
+		t.FailNow()
+	} else if cache.Size() != 1 || !cache.InCache("app.js") {
+		fmt.Println("failed")
+		fmt.Println("[!] file should be cached")
+		t.FailNow()
+	}
+
+	// Files too large to cache are still read, but aren't stored.
+	if data, err := cache.ReadFile("big.js"); err != nil || len(data) != 10*len(content) {
+		fmt.Println("failed")
+		fmt.Println("[!] couldn't read a file larger than MaxSize")
+		t.FailNow()
+	} else if cache.InCache("big.js") {
+		fmt.Println("failed")
+		fmt.Println("[!] file larger than MaxSize shouldn't be cached")
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}
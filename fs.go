@@ -0,0 +1,117 @@
+package filecache
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+)
+
+// A FileCache can be used anywhere a file system is expected, such as
+// with html/template.ParseFS or http.FS.
+var (
+	_ fs.FS         = (*FileCache)(nil)
+	_ fs.ReadFileFS = (*FileCache)(nil)
+	_ fs.StatFS     = (*FileCache)(nil)
+	_ fs.ReadDirFS  = (*FileCache)(nil)
+)
+
+// itemInfo describes a cached file.
+type itemInfo struct {
+	name     string
+	size     int64
+	mode     fs.FileMode
+	modified time.Time
+}
+
+func (fi *itemInfo) Name() string       { return path.Base(fi.name) }
+func (fi *itemInfo) Size() int64        { return fi.size }
+func (fi *itemInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *itemInfo) ModTime() time.Time { return fi.modified }
+func (fi *itemInfo) IsDir() bool        { return false }
+func (fi *itemInfo) Sys() interface{}   { return nil }
+
+func newItemInfo(name string, itm *cacheItem) *itemInfo {
+	return &itemInfo{
+		name:     name,
+		size:     itm.Size,
+		mode:     itm.Mode,
+		modified: itm.Modified,
+	}
+}
+
+// cachedFile is an fs.File that reads from a cached item's contents. It
+// also implements io.Seeker and io.ReaderAt.
+type cachedFile struct {
+	*bytes.Reader
+	info *itemInfo
+}
+
+func (f *cachedFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *cachedFile) Close() error               { return nil }
+
+// Open opens the file named by 'name', which must be a valid fs.FS path.
+// Regular files are cached if they aren't already, and the returned
+// fs.File reads from the cached contents. Directories, and files too large
+// to cache, are opened from the underlying file system.
+func (cache *FileCache) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if cache.InCache(name) {
+		if itm, ok := cache.accessItem(name); ok {
+			cache.served(int(itm.Size))
+			return &cachedFile{
+				Reader: bytes.NewReader(itm.Access()),
+				info:   newItemInfo(name, itm),
+			}, nil
+		}
+	} else if fi, err := cache.stat(name); err == nil && fi.IsDir() {
+		return cache.open(name)
+	} else {
+		cache.miss(name)
+	}
+
+	if itm, _ := cache.load(name, true); itm != nil {
+		cache.served(int(itm.Size))
+		return &cachedFile{
+			Reader: bytes.NewReader(itm.Access()),
+			info:   newItemInfo(name, itm),
+		}, nil
+	}
+	return cache.open(name)
+}
+
+// Stat returns a FileInfo describing the file named by 'name', which must
+// be a valid fs.FS path. Cached files are described as they were when they
+// were cached.
+func (cache *FileCache) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if cache.InCache(name) {
+		if itm, ok := cache.getItem(name); ok {
+			return newItemInfo(name, itm), nil
+		}
+	}
+	return cache.stat(name)
+}
+
+// ReadDir reads the directory named by 'name', which must be a valid fs.FS
+// path, from the underlying file system. Directories are never cached.
+func (cache *FileCache) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return cache.readDir(name)
+}
+
+// readDir reads the directory named by 'name' from the cache's file
+// system.
+func (cache *FileCache) readDir(name string) ([]fs.DirEntry, error) {
+	if cache.fsys != nil {
+		return fs.ReadDir(cache.fsys, name)
+	}
+	return os.ReadDir(cache.osPath(name))
+}
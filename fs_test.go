@@ -0,0 +1,75 @@
+package filecache
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCacheIsFS(t *testing.T) {
+	fmt.Printf("[+] validating FileCache implements fs.FS: ")
+	fsys := fstest.MapFS{
+		"index.html":        {Data: []byte("<h1>{{.}}</h1>")},
+		"static/app.css":    {Data: []byte("body { margin: 0; }")},
+		"static/js/app.js":  {Data: []byte("console.log('hello');")},
+		"static/robots.txt": {Data: []byte("User-agent: *\n")},
+	}
+	cache := NewFSCache(fsys)
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+	}
+
+	// Test with one file cached up front, and the rest cached as the
+	// tests open them.
+	cache.CacheNow("static/app.css")
+	if err := fstest.TestFS(cache, "index.html", "static/app.css",
+		"static/js/app.js", "static/robots.txt"); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!]", err.Error())
+		t.Fail()
+	}
+
+	// Open caches files as they are opened.
+	if cache.Size() != len(fsys) {
+		fmt.Println("failed")
+		fmt.Printf("[!] %d files were cached, expected %d\n", cache.Size(), len(fsys))
+		t.Fail()
+	}
+	if t.Failed() {
+		cache.Stop()
+		t.FailNow()
+	}
+
+	// Run the tests again, this time entirely from the cache.
+	if err := fstest.TestFS(cache, "index.html", "static/app.css",
+		"static/js/app.js", "static/robots.txt"); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!]", err.Error())
+		t.Fail()
+	}
+
+	tmpl, err := template.ParseFS(cache, "index.html")
+	out := new(strings.Builder)
+	if err == nil {
+		err = tmpl.Execute(out, "hello")
+	}
+	if err != nil || out.String() != "<h1>hello</h1>" {
+		fmt.Println("failed")
+		fmt.Println("[!] couldn't parse template from cache:", err)
+		t.Fail()
+	}
+
+	if _, err = fs.Stat(cache, "missing.txt"); err == nil {
+		fmt.Println("failed")
+		fmt.Println("[!] stat of a missing file should fail")
+		t.Fail()
+	}
+	if !t.Failed() {
+		fmt.Println("ok")
+	}
+	cache.Stop()
+}
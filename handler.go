@@ -0,0 +1,373 @@
+package filecache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Index files served for requests for a directory, in order of preference,
+// when a Handler's IndexFiles field is nil.
+var DefaultIndexFiles = []string{"index.html", "index.htm"}
+
+// Handler is an http.Handler that serves the files beneath a root
+// directory from a FileCache. Request paths are cleaned before use, and
+// requests whose paths contain ".." elements are rejected, so that only
+// files beneath the root can be served. Cache keys are the cleaned request
+// paths relative to the root, such as "css/app.css" for /css/app.css.
+//
+// Requests for directories are redirected to add a trailing slash, and
+// requests for files are redirected to remove one, as with net/http's
+// file server. A directory is served by its first index file that exists;
+// if none do, a listing of the directory is served if Listing is set.
+//
+// Only GET and HEAD requests are served; OPTIONS requests are answered
+// with an Allow header, and other methods are refused with 405 Method Not
+// Allowed.
+type Handler struct {
+	cache    *FileCache
+	root     string
+	realRoot string
+
+	// By default, requests for symlinks that resolve to a file outside
+	// the root are refused with 403 Forbidden. Setting FollowSymlinks
+	// serves them instead, and saves resolving the links for every
+	// request. Symlinks are only checked for caches of operating system
+	// files.
+	FollowSymlinks bool
+
+	IndexFiles []string // Index file names (DefaultIndexFiles if nil)
+	Listing    bool     // List directories that have no index file
+
+	// Fallback names a file beneath the root that is served, with 200
+	// OK, in place of files that don't exist, as needed by single-page
+	// applications that route requests in the browser.
+	Fallback string
+
+	// ErrorPages maps HTTP status codes to files beneath the root that
+	// are served as the body of error responses with that status.
+	ErrorPages map[int]string
+
+	// Requests for paths matching any of the Exclude patterns are never
+	// served the Fallback or an error page, so that, for example, an API
+	// can return its own errors. A pattern starting with "." matches
+	// paths with that extension, such as ".png". A pattern containing any
+	// of "*?[" is matched with path.Match against the whole request path
+	// if it contains a slash, and against the last element of the path if
+	// it doesn't, such as "*.min.js". Any other pattern matches paths that
+	// start with it, such as "/api/".
+	Exclude []string
+
+	// CacheRules sets the caching headers sent with files; the first rule
+	// matching a file's request path is used. If FingerprintMaxAge is
+	// set, files with content hashes in their names are instead cached
+	// for that long and marked immutable. Fingerprinted decides which
+	// request paths have content hashes; HasFingerprint is used if it is
+	// nil.
+	CacheRules        []CacheRule
+	FingerprintMaxAge time.Duration
+	Fingerprinted     func(upath string) bool
+
+	listMutex sync.Mutex
+	listings  map[string]*listing
+}
+
+// HttpHandlerRoot returns a Handler serving the files beneath root from
+// the cache. For a cache created by NewFSCache or NewDirCache, root is a
+// slash-separated path within the cache, such as "." or "static", and the
+// cache keys are relative to the cache's root; to cache files under their
+// request paths, create the cache with the handler's root and pass ".".
+// For other caches, root is an operating system directory, and the cache
+// keys are operating system paths beneath it.
+func HttpHandlerRoot(cache *FileCache, root string) *Handler {
+	h := &Handler{cache: cache, listings: make(map[string]*listing)}
+	if !cache.slashNames() {
+		h.root = filepath.Clean(root)
+	} else if h.root = path.Clean(root); cache.fsys != nil {
+		return h
+	}
+
+	h.realRoot = cache.osPath(h.root)
+	if abs, err := filepath.Abs(h.realRoot); err == nil {
+		h.realRoot = abs
+	}
+	if real, err := filepath.EvalSymlinks(h.realRoot); err == nil {
+		h.realRoot = real
+	}
+	return h
+}
+
+func isSlashRune(r rune) bool { return r == '/' || r == '\\' }
+
+// containsDotDot returns true if any element of the path v is "..".
+func containsDotDot(v string) bool {
+	if !strings.Contains(v, "..") {
+		return false
+	}
+	for _, elem := range strings.FieldsFunc(v, isSlashRune) {
+		if elem == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// localRedirect redirects the client to newPath, relative to the request
+// path, keeping the query string.
+func localRedirect(w http.ResponseWriter, r *http.Request, newPath string) {
+	if q := r.URL.RawQuery; q != "" {
+		newPath += "?" + q
+	}
+	w.Header().Set("Location", newPath)
+	w.WriteHeader(http.StatusMovedPermanently)
+}
+
+// join joins a cache key and a relative slash-separated path.
+func (h *Handler) join(name, rel string) string {
+	if h.cache.slashNames() {
+		return path.Join(name, rel)
+	}
+	return filepath.Join(name, filepath.FromSlash(rel))
+}
+
+func (h *Handler) indexFiles() []string {
+	if h.IndexFiles == nil {
+		return DefaultIndexFiles
+	}
+	return h.IndexFiles
+}
+
+// contained returns true if the file named by 'name' resolves to a file
+// beneath the root. Files that can't be resolved, such as those that don't
+// exist, are left for the caller to fail to open.
+func (h *Handler) contained(name string) bool {
+	if h.FollowSymlinks || h.cache.fsys != nil {
+		return true
+	}
+	real, err := filepath.Abs(h.cache.osPath(name))
+	if err == nil {
+		real, err = filepath.EvalSymlinks(real)
+	}
+	if err != nil || real == h.realRoot {
+		return true
+	}
+	prefix := h.realRoot
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	return strings.HasPrefix(real, prefix)
+}
+
+// matchPath returns true if the slash-separated path upath matches the
+// pattern, as described for the Handler's Exclude field.
+func matchPath(pattern, upath string) bool {
+	switch {
+	case strings.ContainsAny(pattern, "*?["):
+		if !strings.Contains(pattern, "/") {
+			upath = path.Base(upath)
+		}
+		ok, _ := path.Match(pattern, upath)
+		return ok
+	case strings.HasPrefix(pattern, "."):
+		return strings.EqualFold(path.Ext(upath), pattern)
+	default:
+		return strings.HasPrefix(upath, pattern)
+	}
+}
+
+// excluded returns true if the request path matches one of the Exclude
+// patterns.
+func (h *Handler) excluded(upath string) bool {
+	for _, pattern := range h.Exclude {
+		if matchPath(pattern, upath) {
+			return true
+		}
+	}
+	return false
+}
+
+// error replies to the request with an HTTP error, using the error page
+// for the status if there is one.
+func (h *Handler) error(w http.ResponseWriter, r *http.Request, status int) {
+	// Error responses shouldn't be cached like the file would have been.
+	w.Header().Del("cache-control")
+	w.Header().Del("expires")
+	page, ok := h.ErrorPages[status]
+	if !ok || h.excluded(r.URL.Path) || !h.serveError(w, r, h.join(h.root, page), status) {
+		httpError(w, r, status)
+	}
+}
+
+// serveError serves the file named by 'name' as the body of an error
+// response, returning false if it can't be read.
+func (h *Handler) serveError(w http.ResponseWriter, r *http.Request, name string, status int) bool {
+	var itm *cacheItem
+	if h.cache.InCache(name) {
+		itm, _ = h.cache.accessItem(name)
+	} else {
+		h.cache.miss(name)
+	}
+	if itm == nil {
+		if itm, _ = h.cache.load(name, true); itm == nil {
+			return false
+		}
+	}
+	content := itm.Access()
+
+	ctype := mime.TypeByExtension(filepath.Ext(name))
+	if ctype == "" {
+		ctype = http.DetectContentType(content)
+	}
+	header := w.Header()
+	header.Set("content-type", ctype)
+	header.Set("content-length", strconv.Itoa(len(content)))
+	w.WriteHeader(status)
+	if r.Method != "HEAD" {
+		w.Write(content)
+	}
+	return true
+}
+
+// fallback serves the Fallback file in place of a file that doesn't
+// exist, returning false if the request shouldn't fall back.
+func (h *Handler) fallback(w http.ResponseWriter, r *http.Request) bool {
+	if h.Fallback == "" || h.excluded(r.URL.Path) {
+		return false
+	}
+	h.serve(w, r, h.join(h.root, h.Fallback))
+	return true
+}
+
+// fsError replies to the request with the HTTP error corresponding to an
+// error from the file system.
+func (h *Handler) fsError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		h.error(w, r, http.StatusNotFound)
+	case errors.Is(err, fs.ErrPermission):
+		h.error(w, r, http.StatusForbidden)
+	default:
+		h.error(w, r, http.StatusInternalServerError)
+	}
+}
+
+// serveFile serves the file named by 'name' directly from the file system.
+func (h *Handler) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	file, err := h.cache.open(name)
+	if err != nil {
+		h.fsError(w, r, err)
+		return
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		h.fsError(w, r, err)
+		return
+	}
+
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			h.fsError(w, r, err)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+	http.ServeContent(w, r, name, fi.ModTime(), content)
+}
+
+// serve serves the regular file named by 'name' from the cache, caching it
+// first if it isn't present. Files that can't be cached are served from the
+// file system.
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request, name string) {
+	h.setCacheHeaders(w, name)
+	if !h.cache.serveCached(w, r, name) {
+		h.serveFile(w, r, name)
+	}
+}
+
+// serveDir serves the directory named by 'name' using its index file or a
+// listing.
+func (h *Handler) serveDir(w http.ResponseWriter, r *http.Request, name string, fi fs.FileInfo) {
+	for _, index := range h.indexFiles() {
+		iname := h.join(name, index)
+		if !h.cache.InCache(iname) {
+			ifi, err := h.cache.stat(iname)
+			if err != nil || !ifi.Mode().IsRegular() {
+				continue
+			}
+		}
+		if h.contained(iname) {
+			h.serve(w, r, iname)
+			return
+		}
+	}
+	if !h.Listing {
+		h.error(w, r, http.StatusForbidden)
+		return
+	}
+	h.serveListing(w, r, name, fi)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, h.error) {
+		return
+	}
+	upath := r.URL.Path
+	if containsDotDot(upath) {
+		h.error(w, r, http.StatusBadRequest)
+		return
+	}
+	for _, index := range h.indexFiles() {
+		if strings.HasSuffix(upath, "/"+index) {
+			localRedirect(w, r, "./")
+			return
+		}
+	}
+
+	rel := strings.TrimPrefix(path.Clean("/"+upath), "/")
+	if rel == "" {
+		rel = "."
+	}
+	name := h.join(h.root, rel)
+	if !h.contained(name) {
+		h.error(w, r, http.StatusForbidden)
+		return
+	}
+	slash := strings.HasSuffix(upath, "/")
+
+	// Only directories need to be looked up on the file system.
+	if h.cache.InCache(name) {
+		if slash {
+			localRedirect(w, r, "../"+path.Base(rel))
+		} else {
+			h.serve(w, r, name)
+		}
+		return
+	}
+
+	fi, err := h.cache.stat(name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && h.fallback(w, r):
+	case err != nil:
+		h.fsError(w, r, err)
+	case fi.IsDir() && !slash:
+		localRedirect(w, r, path.Base(upath)+"/")
+	case fi.IsDir():
+		h.serveDir(w, r, name, fi)
+	case slash:
+		localRedirect(w, r, "../"+path.Base(rel))
+	default:
+		h.serve(w, r, name)
+	}
+}
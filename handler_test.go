@@ -0,0 +1,375 @@
+package filecache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// makeTree creates a temporary directory containing a public directory to
+// serve from, and a secret file beside it. The caller should remove the
+// returned directory when done.
+func makeTree(t *testing.T, files map[string]string) (dir, root string) {
+	dir, err := ioutil.TempDir("", "fctest")
+	if err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] couldn't create temporary directory: ", err.Error())
+		t.FailNow()
+	}
+	root = filepath.Join(dir, "public")
+	files["secret.txt"] = "the secret"
+	for name, contents := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(name), 0700)
+		if err = ioutil.WriteFile(name, []byte(contents), 0600); err != nil {
+			fmt.Println("failed")
+			fmt.Println("[!] couldn't write temporary file: ", err.Error())
+			os.RemoveAll(dir)
+			t.FailNow()
+		}
+	}
+	return dir, root
+}
+
+func serve(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func TestHandlerRoot(t *testing.T) {
+	fmt.Printf("[+] validating root-jailed HTTP handler: ")
+	dir, root := makeTree(t, map[string]string{
+		"public/hello.txt":   "hello, world",
+		"public/css/app.css": "body { margin: 0; }",
+	})
+	defer os.RemoveAll(dir)
+	if err := os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(root, "link.txt")); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] couldn't create symlink: ", err.Error())
+		t.FailNow()
+	}
+
+	cache := NewDirCache(root)
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+	}
+	defer cache.Stop()
+	cache.CacheNow("hello.txt")
+	h := HttpHandlerRoot(cache, ".")
+
+	tests := []struct {
+		target string
+		code   int
+		body   string
+	}{
+		{"/hello.txt", http.StatusOK, "hello, world"},
+		{"/css/app.css", http.StatusOK, "body { margin: 0; }"},
+		{"/css//./app.css", http.StatusOK, "body { margin: 0; }"},
+		{"/../secret.txt", http.StatusBadRequest, ""},
+		{"/css/../../secret.txt", http.StatusBadRequest, ""},
+		{"/css/..%5c..%5csecret.txt", http.StatusBadRequest, ""},
+		{"/" + filepath.ToSlash(filepath.Join(dir, "secret.txt")), http.StatusNotFound, ""},
+		{"/link.txt", http.StatusForbidden, ""},
+		{"/missing.txt", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := serve(h, "GET", test.target)
+		if w.Code != test.code || (test.body != "" && w.Body.String() != test.body) {
+			fmt.Println("failed")
+			fmt.Printf("[!] %s returned %d %q, expected %d %q\n", test.target,
+				w.Code, w.Body.String(), test.code, test.body)
+			t.FailNow()
+		}
+	}
+
+	h.FollowSymlinks = true
+	if w := serve(h, "GET", "/link.txt"); w.Code != http.StatusOK || w.Body.String() != "the secret" {
+		fmt.Println("failed")
+		fmt.Println("[!] symlink should be followed if FollowSymlinks is set")
+		t.FailNow()
+	}
+
+	if !cache.InCache("hello.txt") || !cache.InCache("css/app.css") {
+		fmt.Println("failed")
+		fmt.Println("[!] cache keys should be relative to the root")
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}
+
+func TestHandlerSubRoot(t *testing.T) {
+	fmt.Printf("[+] validating handlers rooted in subdirectories: ")
+	cache := NewFSCache(fstest.MapFS{
+		"static/app.js": {Data: []byte("app()")},
+		"secret.txt":    {Data: []byte("the secret")},
+	})
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+		t.FailNow()
+	}
+	defer cache.Stop()
+
+	// Handlers don't change the cache, so several can share it.
+	for i := 0; i < 2; i++ {
+		h := HttpHandlerRoot(cache, "static")
+		if w := serve(h, "GET", "/app.js"); w.Code != http.StatusOK || w.Body.String() != "app()" {
+			fmt.Println("failed")
+			fmt.Printf("[!] /app.js returned %d %q\n", w.Code, w.Body.String())
+			t.FailNow()
+		} else if w = serve(h, "GET", "/secret.txt"); w.Code != http.StatusNotFound {
+			fmt.Println("failed")
+			fmt.Printf("[!] /secret.txt returned %d\n", w.Code)
+			t.FailNow()
+		}
+	}
+	if !cache.InCache("static/app.js") {
+		fmt.Println("failed")
+		fmt.Println("[!] cache keys should be relative to the cache's root")
+		t.FailNow()
+	} else if data, err := cache.ReadFile("secret.txt"); err != nil || string(data) != "the secret" {
+		fmt.Println("failed")
+		fmt.Printf("[!] reading outside the handler's root returned %q, %v\n", data, err)
+		t.FailNow()
+	}
+
+	// Watched files are invalidated under their names relative to the
+	// cache's root.
+	dir, root := makeTree(t, map[string]string{"public/hello.txt": "hello"})
+	defer os.RemoveAll(dir)
+	cache = NewDirCache(root)
+	cache.Watch = true
+	h := HttpHandlerRoot(cache, ".")
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+		t.FailNow()
+	}
+	defer cache.Stop()
+	serve(h, "GET", "/hello.txt")
+	if itm, ok := cache.getItem("hello.txt"); !ok || !itm.watched {
+		fmt.Println("failed")
+		fmt.Println("[!] file should be cached and watched")
+		t.FailNow()
+	}
+	ioutil.WriteFile(filepath.Join(root, "hello.txt"), []byte("goodbye"), 0600)
+	if !waitForEviction(cache, "hello.txt", 5*time.Second) {
+		fmt.Println("failed")
+		fmt.Println("[!] modified file should have been invalidated")
+		t.FailNow()
+	} else if w := serve(h, "GET", "/hello.txt"); w.Body.String() != "goodbye" {
+		fmt.Println("failed")
+		fmt.Printf("[!] modified file served as %q\n", w.Body.String())
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}
+
+func TestHandlerDirectories(t *testing.T) {
+	fmt.Printf("[+] validating directory indexes and listings: ")
+	dir, root := makeTree(t, map[string]string{
+		"public/index.html":     "<h1>home</h1>",
+		"public/docs/a.txt":     "a",
+		"public/docs/sub/b.md":  "b",
+		"public/docs/<x>.txt":   "x",
+		"public/blog/index.htm": "blog",
+	})
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(root, "linked"), 0700)
+	if err := os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(root, "linked", "index.html")); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] couldn't create symlink: ", err.Error())
+		t.FailNow()
+	}
+
+	cache := NewDefaultCache()
+	h := HttpHandlerRoot(cache, root)
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+	}
+	defer cache.Stop()
+	// An index file leading outside the root is refused even if it has
+	// been cached.
+	cache.CacheNow(filepath.Join(root, "linked", "index.html"))
+
+	tests := []struct {
+		target   string
+		code     int
+		body     string
+		location string
+	}{
+		{"/", http.StatusOK, "<h1>home</h1>", ""},
+		{"/index.html", http.StatusMovedPermanently, "", "./"},
+		{"/blog", http.StatusMovedPermanently, "", "blog/"},
+		{"/blog?page=2", http.StatusMovedPermanently, "", "blog/?page=2"},
+		{"/blog/", http.StatusOK, "blog", ""},
+		{"/blog/index.htm", http.StatusMovedPermanently, "", "./"},
+		{"/docs/a.txt/", http.StatusMovedPermanently, "", "../a.txt"},
+		{"/docs/", http.StatusForbidden, "", ""},
+		{"/linked/", http.StatusForbidden, "", ""},
+	}
+	for _, test := range tests {
+		w := serve(h, "GET", test.target)
+		if w.Code != test.code || (test.body != "" && w.Body.String() != test.body) ||
+			w.Header().Get("location") != test.location {
+			fmt.Println("failed")
+			fmt.Printf("[!] %s returned %d %q (location %q), expected %d %q (location %q)\n",
+				test.target, w.Code, w.Body.String(), w.Header().Get("location"),
+				test.code, test.body, test.location)
+			t.FailNow()
+		}
+	}
+
+	h.Listing = true
+	w := serve(h, "GET", "/docs/")
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, `<a href="a.txt">a.txt</a>`) ||
+		!strings.Contains(body, `<a href="sub/">sub/</a>`) ||
+		!strings.Contains(body, "&lt;x&gt;.txt") || strings.Contains(body, "<x>") {
+		fmt.Println("failed")
+		fmt.Printf("[!] bad HTML listing: %d %q\n", w.Code, body)
+		t.FailNow()
+	}
+
+	r := httptest.NewRequest("GET", "/docs/", nil)
+	r.Header.Set("accept", "application/json")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var entries []struct {
+		Name  string `json:"name"`
+		IsDir bool   `json:"is_dir"`
+		Size  int64  `json:"size"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] couldn't decode JSON listing: ", err.Error())
+		t.FailNow()
+	} else if len(entries) != 3 || entries[1].Name != "a.txt" || entries[1].Size != 1 ||
+		!entries[2].IsDir {
+		fmt.Println("failed")
+		fmt.Printf("[!] bad JSON listing: %+v\n", entries)
+		t.FailNow()
+	}
+
+	// Files rewritten in place don't modify the directory, but should
+	// still be listed as they are.
+	docs := filepath.Join(root, "docs")
+	dfi, _ := os.Stat(docs)
+	ioutil.WriteFile(filepath.Join(docs, "a.txt"), []byte("aaaa"), 0600)
+	os.Chtimes(docs, dfi.ModTime(), dfi.ModTime())
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil || entries[1].Size != 4 {
+		fmt.Println("failed")
+		fmt.Printf("[!] listing of rewritten file is stale: %+v\n", entries)
+		t.FailNow()
+	}
+
+	// Unchanged listings aren't rendered again.
+	rendered := h.listings[docs]
+	if serve(h, "GET", "/docs/"); rendered == nil || h.listings[docs] != rendered {
+		fmt.Println("failed")
+		fmt.Println("[!] unchanged listing was rendered again")
+		t.FailNow()
+	}
+
+	// The listing should be refreshed when the directory changes.
+	ioutil.WriteFile(filepath.Join(root, "docs", "c.txt"), []byte("c"), 0600)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(root, "docs"), future, future)
+	if w = serve(h, "GET", "/docs/"); !strings.Contains(w.Body.String(), "c.txt") {
+		fmt.Println("failed")
+		fmt.Println("[!] listing should be refreshed when the directory is modified")
+		t.FailNow()
+	}
+
+	// Only so many listings are kept.
+	for i := 0; i <= maxListings; i++ {
+		os.MkdirAll(filepath.Join(root, "many", strconv.Itoa(i)), 0700)
+		serve(h, "GET", fmt.Sprintf("/many/%d/", i))
+	}
+	if len(h.listings) > maxListings {
+		fmt.Println("failed")
+		fmt.Printf("[!] %d listings kept, expected at most %d\n", len(h.listings), maxListings)
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}
+
+func TestHandlerFallback(t *testing.T) {
+	fmt.Printf("[+] validating fallback and error pages: ")
+	dir, root := makeTree(t, map[string]string{
+		"public/index.html":   "<h1>app</h1>",
+		"public/404.html":     "<h1>not here</h1>",
+		"public/405.html":     "<h1>not allowed</h1>",
+		"public/docs/a.txt":   "a",
+		"public/api/ping.txt": "pong",
+	})
+	defer os.RemoveAll(dir)
+
+	cache := NewDefaultCache()
+	h := HttpHandlerRoot(cache, root)
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+	}
+	defer cache.Stop()
+	h.Fallback = "index.html"
+	h.ErrorPages = map[int]string{
+		http.StatusNotFound:         "404.html",
+		http.StatusMethodNotAllowed: "405.html",
+		http.StatusForbidden:        "missing.html",
+	}
+	h.Exclude = []string{"/api/", "*.png"}
+
+	tests := []struct {
+		method string
+		target string
+		code   int
+		body   string
+	}{
+		{"GET", "/users/42", http.StatusOK, "<h1>app</h1>"},
+		{"GET", "/docs/a.txt", http.StatusOK, "a"},
+		{"HEAD", "/users/42", http.StatusOK, ""},
+		{"POST", "/users/42", http.StatusMethodNotAllowed, "<h1>not allowed</h1>"},
+		{"GET", "/api/missing", http.StatusNotFound, "404 Not Found\n"},
+		{"GET", "/api/ping.txt", http.StatusOK, "pong"},
+		{"GET", "/logo.png", http.StatusNotFound, "404 Not Found\n"},
+		{"GET", "/docs/", http.StatusForbidden, "403 Forbidden\n"},
+	}
+	for _, test := range tests {
+		w := serve(h, test.method, test.target)
+		if w.Code != test.code || (test.body != "" && w.Body.String() != test.body) {
+			fmt.Println("failed")
+			fmt.Printf("[!] %s %s returned %d %q, expected %d %q\n", test.method,
+				test.target, w.Code, w.Body.String(), test.code, test.body)
+			t.FailNow()
+		}
+	}
+
+	h.Fallback = ""
+	w := serve(h, "GET", "/users/42")
+	if w.Code != http.StatusNotFound || w.Body.String() != "<h1>not here</h1>" {
+		fmt.Println("failed")
+		fmt.Printf("[!] missing file returned %d %q without a fallback\n", w.Code, w.Body.String())
+		t.FailNow()
+	}
+	if ct := w.Header().Get("content-type"); !strings.HasPrefix(ct, "text/html") {
+		fmt.Println("failed")
+		fmt.Printf("[!] error page served with content type %q\n", ct)
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}
@@ -0,0 +1,93 @@
+package filecache
+
+import "sync"
+
+// hooks holds the callbacks registered with a cache.
+type hooks struct {
+	mutex     sync.RWMutex
+	evict     []func(name string, reason EvictReason)
+	loadError []func(name string, err error)
+	insert    []func(name string)
+	hit       []func(name string)
+	miss      []func(name string)
+}
+
+// The hooks are called synchronously by the goroutine that caused the
+// event, without any of the cache's locks held, so they may call the
+// cache's methods. They should return quickly, as the caller waits for
+// them. Hooks for the same event are called in the order they were
+// registered.
+
+// OnEvict registers fn to be called whenever an item is removed from the
+// cache, with the reason it was removed.
+func (cache *FileCache) OnEvict(fn func(name string, reason EvictReason)) {
+	cache.hooks.mutex.Lock()
+	cache.hooks.evict = append(cache.hooks.evict, fn)
+	cache.hooks.mutex.Unlock()
+}
+
+// OnLoadError registers fn to be called whenever a file can't be cached,
+// including when it is being cached in the background by Cache, with the
+// reason why: an error reading the file, or ItemTooLarge. Directories and
+// files turned away by the admission policy aren't failures, and aren't
+// reported. Other readers of the file wait for fn to return, so fn must
+// not read the file through the cache itself.
+func (cache *FileCache) OnLoadError(fn func(name string, err error)) {
+	cache.hooks.mutex.Lock()
+	cache.hooks.loadError = append(cache.hooks.loadError, fn)
+	cache.hooks.mutex.Unlock()
+}
+
+// OnInsert registers fn to be called whenever a file is added to the
+// cache. As with OnLoadError, fn must not read the file through the cache.
+func (cache *FileCache) OnInsert(fn func(name string)) {
+	cache.hooks.mutex.Lock()
+	cache.hooks.insert = append(cache.hooks.insert, fn)
+	cache.hooks.mutex.Unlock()
+}
+
+// OnHit registers fn to be called whenever a lookup finds a file in the
+// cache.
+func (cache *FileCache) OnHit(fn func(name string)) {
+	cache.hooks.mutex.Lock()
+	cache.hooks.hit = append(cache.hooks.hit, fn)
+	cache.hooks.mutex.Unlock()
+}
+
+// OnMiss registers fn to be called whenever a lookup doesn't find a file
+// in the cache.
+func (cache *FileCache) OnMiss(fn func(name string)) {
+	cache.hooks.mutex.Lock()
+	cache.hooks.miss = append(cache.hooks.miss, fn)
+	cache.hooks.mutex.Unlock()
+}
+
+// onEvict calls the OnEvict hooks.
+func (h *hooks) onEvict(name string, reason EvictReason) {
+	h.mutex.RLock()
+	fns := h.evict
+	h.mutex.RUnlock()
+	for _, fn := range fns {
+		fn(name, reason)
+	}
+}
+
+// onLoadError calls the OnLoadError hooks.
+func (h *hooks) onLoadError(name string, err error) {
+	h.mutex.RLock()
+	fns := h.loadError
+	h.mutex.RUnlock()
+	for _, fn := range fns {
+		fn(name, err)
+	}
+}
+
+// call calls each of fns, which are one of h's lists of hooks.
+func (h *hooks) call(fns *[]func(name string), name string) {
+	h.mutex.RLock()
+	list := *fns
+	h.mutex.RUnlock()
+	for _, fn := range list {
+		fn(name)
+	}
+}
@@ -0,0 +1,93 @@
+package filecache
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	fmt.Printf("[+] testing event hooks: ")
+	cache := NewFSCache(fstest.MapFS{
+		"a.txt":     {Data: []byte("hello")},
+		"b.txt":     {Data: []byte("world")},
+		"dir/c.txt": {Data: []byte("!")},
+	})
+	cache.MaxItems = 1
+
+	var mutex sync.Mutex
+	var events []string
+	event := func(format string, args ...interface{}) {
+		mutex.Lock()
+		events = append(events, fmt.Sprintf(format, args...))
+		mutex.Unlock()
+	}
+	loadFailed := make(chan struct{}, 1)
+	cache.OnHit(func(name string) { event("hit %s", name) })
+	cache.OnMiss(func(name string) { event("miss %s", name) })
+	cache.OnInsert(func(name string) { event("insert %s", name) })
+	cache.OnEvict(func(name string, reason EvictReason) {
+		// Hooks run without the cache's locks held.
+		event("evict %s %s, %d left", name, reason, cache.Size())
+	})
+	cache.OnLoadError(func(name string, err error) {
+		if _, ok := err.(*fs.PathError); ok {
+			event("error %s", name)
+		} else {
+			event("error %s: %v", name, err)
+		}
+		select {
+		case loadFailed <- struct{}{}:
+		default:
+		}
+	})
+
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+		t.FailNow()
+	}
+	cache.ReadFile("a.txt")
+	cache.ReadFile("a.txt")
+	cache.ReadFile("b.txt")
+
+	// Directories are neither misses nor load errors.
+	if f, err := cache.Open("dir"); err == nil {
+		f.Close()
+	}
+	cache.HttpWriteFile(httptest.NewRecorder(), httptest.NewRequest("GET", "/dir", nil))
+	cache.Cache("missing.txt")
+	select {
+	case <-loadFailed:
+	case <-time.After(time.Second):
+		fmt.Println("failed")
+		fmt.Println("[!] background load error wasn't reported")
+		t.FailNow()
+	}
+	cache.Stop()
+
+	expect := []string{
+		"miss a.txt",
+		"insert a.txt",
+		"hit a.txt",
+		"miss b.txt",
+		"evict a.txt capacity, 0 left",
+		"insert b.txt",
+		"error missing.txt",
+		"evict b.txt manual, 0 left",
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !reflect.DeepEqual(events, expect) {
+		fmt.Println("failed")
+		fmt.Printf("[!] expected events %q\n", expect)
+		fmt.Printf("[!]      got events %q\n", events)
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}
@@ -0,0 +1,96 @@
+package filecache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+)
+
+// contentTag returns a strong HTTP entity tag for content.
+func contentTag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// The methods files are served to, as sent in Allow headers.
+const allowedMethods = "GET, HEAD, OPTIONS"
+
+// httpError replies to the request with a plain text HTTP error.
+func httpError(w http.ResponseWriter, r *http.Request, status int) {
+	http.Error(w, fmt.Sprintf("%d %s", status, http.StatusText(status)), status)
+}
+
+// allowMethod returns true if the request's method is GET or HEAD. OPTIONS
+// requests are answered with the allowed methods, and requests with other
+// methods are refused with 405 Method Not Allowed, using fail to write the
+// error.
+func allowMethod(w http.ResponseWriter, r *http.Request,
+	fail func(http.ResponseWriter, *http.Request, int)) bool {
+	switch r.Method {
+	case "GET", "HEAD":
+		return true
+	case "OPTIONS":
+		w.Header().Set("allow", allowedMethods)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("allow", allowedMethods)
+		fail(w, r, http.StatusMethodNotAllowed)
+	}
+	return false
+}
+
+// serveCached serves the file named by 'name' from the cache, loading it
+// first if it isn't present, and returns false if it can't be cached.
+// Directories are left to the caller without being counted as misses.
+// Concurrent requests for the same file share a single read. If the
+// request is cancelled while the file is loading, nothing is served.
+func (cache *FileCache) serveCached(w http.ResponseWriter, r *http.Request, name string) bool {
+	if cache.InCache(name) {
+		if itm, ok := cache.accessItem(name); ok {
+			cache.serveItem(w, r, name, itm)
+			return true
+		}
+	} else if fi, err := cache.stat(name); err == nil && fi.IsDir() {
+		return false
+	} else {
+		cache.miss(name)
+	}
+
+	itm, _ := cache.loadContext(r.Context(), name, true)
+	if itm == nil {
+		return r.Context().Err() != nil
+	}
+	cache.serveItem(w, r, name, itm)
+	return true
+}
+
+// serveItem serves a cached file.
+func (cache *FileCache) serveItem(w http.ResponseWriter, r *http.Request, name string, itm *cacheItem) {
+	content := itm.Access()
+	ctype := http.DetectContentType(content)
+	mtype := mime.TypeByExtension(filepath.Ext(name))
+	if mtype != "" && mtype != ctype {
+		ctype = mtype
+	}
+	header := w.Header()
+	etag := itm.etag
+	if len(itm.variants) > 0 {
+		header.Add("vary", "accept-encoding")
+		enc := negotiateEncoding(r.Header.Get("accept-encoding"), itm.variants)
+		if v := itm.variants[enc]; v != nil {
+			content, etag = v.content, v.etag
+			header.Set("content-encoding", enc)
+		}
+	}
+	header.Set("etag", etag)
+	header.Set("content-disposition",
+		fmt.Sprintf("filename=%s", filepath.Base(name)))
+	header.Set("content-type", ctype)
+	cw := &countingWriter{ResponseWriter: w}
+	http.ServeContent(cw, r, name, itm.Modified, bytes.NewReader(content))
+	cache.served(cw.n)
+}
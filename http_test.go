@@ -0,0 +1,277 @@
+package filecache
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func startHTTPCache(t *testing.T, fsys fstest.MapFS) *FileCache {
+	cache := NewFSCache(fsys)
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+		t.FailNow()
+	}
+	for name := range fsys {
+		if err := cache.CacheNow(name); err != nil {
+			fmt.Println("failed")
+			fmt.Printf("[!] failed to cache %s: %s\n", name, err.Error())
+			cache.Stop()
+			t.FailNow()
+		}
+	}
+	return cache
+}
+
+func doRequest(cache *FileCache, method, target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for key, values := range header {
+		r.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	cache.HttpWriteFile(w, r)
+	return w
+}
+
+func TestConditionalGet(t *testing.T) {
+	fmt.Printf("[+] testing conditional requests for cached files: ")
+	modified := time.Date(2012, 11, 5, 12, 0, 0, 0, time.UTC)
+	cache := startHTTPCache(t, fstest.MapFS{
+		"app.js": {Data: []byte("console.log('hello');"), ModTime: modified},
+	})
+	defer cache.Stop()
+
+	w := doRequest(cache, "GET", "/app.js", nil)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		fmt.Println("failed")
+		fmt.Printf("[!] expected 200 with an ETag, got %d\n", w.Code)
+		t.FailNow()
+	} else if lm := w.Header().Get("Last-Modified"); lm != modified.Format(http.TimeFormat) {
+		fmt.Println("failed")
+		fmt.Printf("[!] wrong Last-Modified header: %s\n", lm)
+		t.FailNow()
+	}
+
+	tests := []struct {
+		header http.Header
+		code   int
+	}{
+		{http.Header{"If-None-Match": {etag}}, http.StatusNotModified},
+		{http.Header{"If-None-Match": {`"other", W/` + etag}}, http.StatusNotModified},
+		{http.Header{"If-None-Match": {"*"}}, http.StatusNotModified},
+		{http.Header{"If-None-Match": {`"other"`}}, http.StatusOK},
+		{http.Header{"If-Modified-Since": {modified.Format(http.TimeFormat)}}, http.StatusNotModified},
+		{http.Header{"If-Modified-Since": {modified.Add(-time.Hour).Format(http.TimeFormat)}}, http.StatusOK},
+		{http.Header{
+			"If-None-Match":     {`"other"`},
+			"If-Modified-Since": {modified.Format(http.TimeFormat)},
+		}, http.StatusOK},
+	}
+	for _, test := range tests {
+		w = doRequest(cache, "GET", "/app.js", test.header)
+		if w.Code != test.code {
+			fmt.Println("failed")
+			fmt.Printf("[!] request with %v returned %d, expected %d\n",
+				test.header, w.Code, test.code)
+			t.FailNow()
+		} else if w.Code == http.StatusNotModified && w.Body.Len() != 0 {
+			fmt.Println("failed")
+			fmt.Println("[!] 304 response should not have a body")
+			t.FailNow()
+		}
+	}
+	fmt.Println("ok")
+}
+
+func TestRangeRequest(t *testing.T) {
+	fmt.Printf("[+] testing range requests for cached files: ")
+	modified := time.Date(2012, 11, 5, 12, 0, 0, 0, time.UTC)
+	cache := startHTTPCache(t, fstest.MapFS{
+		"video.mp4": {Data: []byte("0123456789abcdef"), ModTime: modified},
+	})
+	defer cache.Stop()
+
+	etag := doRequest(cache, "GET", "/video.mp4", nil).Header().Get("ETag")
+	tests := []struct {
+		header http.Header
+		code   int
+		body   string
+	}{
+		{http.Header{"Range": {"bytes=4-7"}}, http.StatusPartialContent, "4567"},
+		{http.Header{"Range": {"bytes=-3"}}, http.StatusPartialContent, "def"},
+		{http.Header{"Range": {"bytes=32-"}}, http.StatusRequestedRangeNotSatisfiable, ""},
+		{http.Header{"Range": {"bytes=0-1"}, "If-Range": {etag}}, http.StatusPartialContent, "01"},
+		{http.Header{"Range": {"bytes=0-1"}, "If-Range": {`"stale"`}}, http.StatusOK, "0123456789abcdef"},
+	}
+	for _, test := range tests {
+		w := doRequest(cache, "GET", "/video.mp4", test.header)
+		if w.Code != test.code || (test.body != "" && w.Body.String() != test.body) {
+			fmt.Println("failed")
+			fmt.Printf("[!] request with %v returned %d %q, expected %d %q\n",
+				test.header, w.Code, w.Body.String(), test.code, test.body)
+			t.FailNow()
+		}
+	}
+
+	w := doRequest(cache, "GET", "/video.mp4", http.Header{"Range": {"bytes=0-1,4-5"}})
+	if w.Code != http.StatusPartialContent ||
+		!strings.HasPrefix(w.Header().Get("Content-Type"), "multipart/byteranges") {
+		fmt.Println("failed")
+		fmt.Println("[!] multiple ranges should return a multipart response")
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}
+
+func TestContentEncoding(t *testing.T) {
+	fmt.Printf("[+] testing negotiation of compressed variants: ")
+	script := strings.Repeat("console.log('hello');\n", 32)
+	fsys := fstest.MapFS{
+		"app.js":    {Data: []byte(script)},
+		"app.js.br": {Data: []byte("pretend brotli")},
+	}
+	cache := NewFSCache(fsys)
+	cache.Compress = true
+	cache.Precompressed = true
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+	}
+	defer cache.Stop()
+	cache.CacheNow("app.js")
+
+	if size := cache.FileSize(); size <= int64(len(script)+len("pretend brotli")) {
+		fmt.Println("failed")
+		fmt.Printf("[!] variants should be counted in FileSize (got %d)\n", size)
+		t.FailNow()
+	}
+
+	tests := []struct {
+		accept   string
+		encoding string
+	}{
+		{"", ""},
+		{"gzip, br", "br"},
+		{"gzip", "gzip"},
+		{"br;q=0, gzip;q=0.5", "gzip"},
+		{"gzip;q=0.2, br;q=0.1", "gzip"},
+		{"*", "br"},
+		{"deflate", ""},
+	}
+	etags := make(map[string]string)
+	for _, test := range tests {
+		w := doRequest(cache, "GET", "/app.js", http.Header{"Accept-Encoding": {test.accept}})
+		if enc := w.Header().Get("Content-Encoding"); enc != test.encoding {
+			fmt.Println("failed")
+			fmt.Printf("[!] Accept-Encoding %q chose %q, expected %q\n",
+				test.accept, enc, test.encoding)
+			t.FailNow()
+		} else if w.Header().Get("Vary") != "accept-encoding" {
+			fmt.Println("failed")
+			fmt.Println("[!] response should vary on Accept-Encoding")
+			t.FailNow()
+		} else if ctype := w.Header().Get("Content-Type"); !strings.HasPrefix(ctype, "text/javascript") {
+			fmt.Println("failed")
+			fmt.Printf("[!] wrong content type %s\n", ctype)
+			t.FailNow()
+		}
+
+		var body string
+		switch test.encoding {
+		case "gzip":
+			zr, err := gzip.NewReader(w.Body)
+			if err == nil {
+				var raw []byte
+				raw, err = ioutil.ReadAll(zr)
+				body = string(raw)
+			}
+			if err != nil || body != script {
+				fmt.Println("failed")
+				fmt.Println("[!] gzip variant does not match original")
+				t.FailNow()
+			}
+		case "br":
+			body = w.Body.String()
+			if body != "pretend brotli" {
+				fmt.Println("failed")
+				fmt.Println("[!] precompressed variant not served")
+				t.FailNow()
+			}
+		default:
+			if w.Body.String() != script {
+				fmt.Println("failed")
+				fmt.Println("[!] unencoded response does not match original")
+				t.FailNow()
+			}
+		}
+		if etag, ok := etags[test.encoding]; ok && etag != w.Header().Get("ETag") {
+			fmt.Println("failed")
+			fmt.Println("[!] ETag should be stable for each encoding")
+			t.FailNow()
+		}
+		etags[test.encoding] = w.Header().Get("ETag")
+	}
+	if len(etags) != 3 || etags[""] == etags["gzip"] || etags["gzip"] == etags["br"] {
+		fmt.Println("failed")
+		fmt.Println("[!] each encoding should have its own ETag")
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}
+
+func TestMethods(t *testing.T) {
+	fmt.Printf("[+] testing HTTP method handling: ")
+	cache := startHTTPCache(t, fstest.MapFS{
+		"app.js": {Data: []byte("console.log('hello');")},
+	})
+	defer cache.Stop()
+	// An uncached file, served from the file system.
+	cache.fsys.(fstest.MapFS)["other.js"] = &fstest.MapFile{Data: []byte("other();")}
+
+	handlers := map[string]http.Handler{
+		"HttpWriteFile": http.HandlerFunc(cache.HttpWriteFile),
+		"Handler":       HttpHandlerRoot(cache, "."),
+	}
+	tests := []struct {
+		method string
+		target string
+		code   int
+		length string
+		body   string
+	}{
+		{"GET", "/app.js", http.StatusOK, "21", "console.log('hello');"},
+		{"HEAD", "/app.js", http.StatusOK, "21", ""},
+		{"HEAD", "/other.js", http.StatusOK, "8", ""},
+		{"OPTIONS", "/app.js", http.StatusNoContent, "", ""},
+		{"POST", "/app.js", http.StatusMethodNotAllowed, "", "405 Method Not Allowed\n"},
+		{"DELETE", "/other.js", http.StatusMethodNotAllowed, "", "405 Method Not Allowed\n"},
+	}
+	for hname, h := range handlers {
+		for _, test := range tests {
+			w := serve(h, test.method, test.target)
+			if w.Code != test.code || w.Body.String() != test.body ||
+				w.Header().Get("content-length") != test.length {
+				fmt.Println("failed")
+				fmt.Printf("[!] %s: %s %s returned %d %q (length %q), expected %d %q (length %q)\n",
+					hname, test.method, test.target, w.Code, w.Body.String(),
+					w.Header().Get("content-length"), test.code, test.body, test.length)
+				t.FailNow()
+			}
+			allow := w.Header().Get("allow")
+			if test.method != "GET" && test.method != "HEAD" && allow != "GET, HEAD, OPTIONS" {
+				fmt.Println("failed")
+				fmt.Printf("[!] %s: %s returned Allow header %q\n", hname, test.method, allow)
+				t.FailNow()
+			}
+		}
+	}
+	fmt.Println("ok")
+}
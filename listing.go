@@ -0,0 +1,168 @@
+package filecache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxListings bounds the number of directory listings a Handler keeps.
+const maxListings = 256
+
+// listing is a rendered listing of a directory. The names are kept until
+// the directory is modified. Rewriting a file doesn't modify its
+// directory, so the files are looked up again each time the listing is
+// served, and it is rendered again if any of them have changed.
+type listing struct {
+	modified     time.Time // when the directory was modified
+	names        []string
+	entries      []listEntry
+	lastModified time.Time // the latest of modified and the entries' times
+	html, json   []byte
+}
+
+// listEntry describes a file in a JSON directory listing.
+type listEntry struct {
+	Name    string    `json:"name"`
+	IsDir   bool      `json:"is_dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+func (e listEntry) equal(other listEntry) bool {
+	return e.Name == other.Name && e.IsDir == other.IsDir &&
+		e.Size == other.Size && e.ModTime.Equal(other.ModTime)
+}
+
+// current returns true if the listing was made from the directory as it
+// was modified at 'modified', and its files are still described by
+// entries.
+func (l *listing) current(modified time.Time, entries []listEntry) bool {
+	if !l.modified.Equal(modified) || len(l.entries) != len(entries) {
+		return false
+	}
+	for i := range entries {
+		if !l.entries[i].equal(entries[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// getListing returns the listing of the directory named by 'name',
+// reading the directory if it has been modified since it was last listed,
+// and rendering it again if it or any of its files have changed.
+func (h *Handler) getListing(name string, fi fs.FileInfo) (*listing, error) {
+	h.listMutex.Lock()
+	l, ok := h.listings[name]
+	h.listMutex.Unlock()
+
+	var names []string
+	if ok && l.modified.Equal(fi.ModTime()) {
+		names = l.names
+	} else {
+		dirs, err := h.cache.readDir(name)
+		if err != nil {
+			return nil, err
+		}
+		names = make([]string, len(dirs))
+		for i, d := range dirs {
+			names[i] = d.Name()
+		}
+	}
+	entries := h.entries(name, names)
+	if ok && l.current(fi.ModTime(), entries) {
+		return l, nil
+	}
+
+	l, err := newListing(fi.ModTime(), names, entries)
+	if err != nil {
+		return nil, err
+	}
+	h.listMutex.Lock()
+	if _, ok := h.listings[name]; !ok && len(h.listings) >= maxListings {
+		// Make room by forgetting an arbitrary listing.
+		for n := range h.listings {
+			delete(h.listings, n)
+			break
+		}
+	}
+	h.listings[name] = l
+	h.listMutex.Unlock()
+	return l, nil
+}
+
+// entries looks up the files named in the listing of the directory named
+// by 'name'. Files that can't be looked up are left out.
+func (h *Handler) entries(name string, names []string) []listEntry {
+	entries := make([]listEntry, 0, len(names))
+	for _, n := range names {
+		fi, err := h.cache.stat(h.join(name, n))
+		if err != nil {
+			continue
+		}
+		entries = append(entries, listEntry{n, fi.IsDir(), fi.Size(), fi.ModTime()})
+	}
+	return entries
+}
+
+// newListing renders a listing of a directory modified at 'modified'.
+func newListing(modified time.Time, names []string, entries []listEntry) (*listing, error) {
+	l := &listing{
+		modified:     modified,
+		names:        names,
+		entries:      entries,
+		lastModified: modified,
+		html:         listHTML(entries),
+	}
+	for _, e := range entries {
+		if e.ModTime.After(l.lastModified) {
+			l.lastModified = e.ModTime
+		}
+	}
+	var err error
+	if l.json, err = json.Marshal(entries); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// listHTML renders a listing's entries as HTML.
+func listHTML(entries []listEntry) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString("<pre>\n")
+	for _, e := range entries {
+		name := e.Name
+		if e.IsDir {
+			name += "/"
+		}
+		u := url.URL{Path: name}
+		fmt.Fprintf(buf, "<a href=\"%s\">%s</a>\n", html.EscapeString(u.String()),
+			html.EscapeString(name))
+	}
+	buf.WriteString("</pre>\n")
+	return buf.Bytes()
+}
+
+// serveListing serves a listing of the directory named by 'name', as JSON
+// if the client accepts it and HTML otherwise.
+func (h *Handler) serveListing(w http.ResponseWriter, r *http.Request, name string, fi fs.FileInfo) {
+	l, err := h.getListing(name, fi)
+	if err != nil {
+		h.fsError(w, r, err)
+		return
+	}
+	content, ctype := l.html, "text/html; charset=utf-8"
+	if strings.Contains(r.Header.Get("accept"), "application/json") {
+		content, ctype = l.json, "application/json"
+	}
+	w.Header().Add("vary", "accept")
+	w.Header().Set("content-type", ctype)
+	http.ServeContent(w, r, name, l.lastModified, bytes.NewReader(content))
+}
@@ -0,0 +1,145 @@
+// Package prometheus exposes the statistics of file caches in the
+// Prometheus text exposition format, without depending on the Prometheus
+// client library.
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gokyle/filecache"
+)
+
+// ContentType is the content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// A Handler serves the statistics of the caches registered with it. Each
+// cache's metrics are labelled with the name it was registered under, so
+// that several caches can be scraped from one process. The zero value is
+// ready to use.
+type Handler struct {
+	mutex  sync.Mutex
+	caches map[string]*filecache.FileCache
+}
+
+// NewHandler returns a Handler serving the statistics of cache, labelled
+// with name.
+func NewHandler(name string, cache *filecache.FileCache) *Handler {
+	h := new(Handler)
+	h.Register(name, cache)
+	return h
+}
+
+// Register adds cache to the caches served by h, labelled with name,
+// replacing any cache already registered under that name.
+func (h *Handler) Register(name string, cache *filecache.FileCache) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.caches == nil {
+		h.caches = make(map[string]*filecache.FileCache)
+	}
+	h.caches[name] = cache
+}
+
+// Unregister removes the cache registered under name.
+func (h *Handler) Unregister(name string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.caches, name)
+}
+
+// sample is a snapshot of one cache's statistics.
+type sample struct {
+	name  string
+	stats filecache.Stats
+}
+
+// samples returns the statistics of the registered caches, sorted by name.
+func (h *Handler) samples() []sample {
+	h.mutex.Lock()
+	samples := make([]sample, 0, len(h.caches))
+	for name, cache := range h.caches {
+		samples = append(samples, sample{name: name, stats: cache.Stats()})
+	}
+	h.mutex.Unlock()
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].name < samples[j].name
+	})
+	return samples
+}
+
+// A metric describes one of the metrics served, and how to read it from
+// a cache's statistics.
+type metric struct {
+	name  string
+	kind  string
+	help  string
+	value func(s *filecache.Stats) float64
+}
+
+var metrics = []metric{
+	{"filecache_items", "gauge", "Number of files in the cache.",
+		func(s *filecache.Stats) float64 { return float64(s.Items) }},
+	{"filecache_bytes", "gauge", "Size of the files in the cache, in bytes.",
+		func(s *filecache.Stats) float64 { return float64(s.Bytes) }},
+	{"filecache_queue_length", "gauge", "Number of files waiting to be cached.",
+		func(s *filecache.Stats) float64 { return float64(s.Queued) }},
+	{"filecache_hit_ratio", "gauge", "Fraction of lookups that found the file in the cache.",
+		func(s *filecache.Stats) float64 { return s.HitRatio() }},
+	{"filecache_hits_total", "counter", "Lookups that found the file in the cache.",
+		func(s *filecache.Stats) float64 { return float64(s.Hits) }},
+	{"filecache_misses_total", "counter", "Lookups that didn't find the file in the cache.",
+		func(s *filecache.Stats) float64 { return float64(s.Misses) }},
+	{"filecache_loads_total", "counter", "Files read into the cache.",
+		func(s *filecache.Stats) float64 { return float64(s.Loads) }},
+	{"filecache_load_errors_total", "counter", "Files that couldn't be read into the cache.",
+		func(s *filecache.Stats) float64 { return float64(s.LoadErrors) }},
+	{"filecache_load_seconds_total", "counter", "Time spent loading files, in seconds.",
+		func(s *filecache.Stats) float64 { return s.LoadTime.Seconds() }},
+	{"filecache_disk_read_bytes_total", "counter", "Bytes read from the file system.",
+		func(s *filecache.Stats) float64 { return float64(s.DiskBytes) }},
+	{"filecache_served_bytes_total", "counter", "Bytes of cached files handed out.",
+		func(s *filecache.Stats) float64 { return float64(s.ServedBytes) }},
+	{"filecache_dropped_total", "counter", "Files dropped because the queue was full.",
+		func(s *filecache.Stats) float64 { return float64(s.Dropped) }},
+}
+
+// ServeHTTP writes the statistics of the registered caches.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	samples := h.samples()
+	w.Header().Set("content-type", ContentType)
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	for _, m := range metrics {
+		writeHeader(bw, m.name, m.kind, m.help)
+		for i := range samples {
+			fmt.Fprintf(bw, "%s{cache=\"%s\"} %v\n", m.name,
+				escape(samples[i].name), m.value(&samples[i].stats))
+		}
+	}
+
+	writeHeader(bw, "filecache_evictions_total", "counter",
+		"Items removed from the cache, by reason.")
+	for _, s := range samples {
+		for reason, n := range s.stats.Evictions {
+			fmt.Fprintf(bw, "filecache_evictions_total{cache=\"%s\",reason=\"%s\"} %d\n",
+				escape(s.name), filecache.EvictReason(reason), n)
+		}
+	}
+}
+
+func writeHeader(w *bufio.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escape escapes a label value.
+func escape(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,68 @@
+package prometheus
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gokyle/filecache"
+)
+
+func TestHandler(t *testing.T) {
+	fmt.Printf("[+] testing prometheus metrics: ")
+	fsys := fstest.MapFS{"a.txt": {Data: []byte("hello")}}
+	static := filecache.NewFSCache(fsys)
+	assets := filecache.NewFSCache(fsys)
+	for _, cache := range []*filecache.FileCache{static, assets} {
+		if err := cache.Start(); err != nil {
+			fmt.Println("failed")
+			fmt.Println("[!] cache failed to start: ", err.Error())
+			t.FailNow()
+		}
+		defer cache.Stop()
+	}
+	static.ReadFile("a.txt")
+	static.ReadFile("a.txt")
+	static.Remove("a.txt")
+
+	h := NewHandler("static", static)
+	h.Register(`as"sets`, assets)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ctype := w.Header().Get("content-type"); ctype != ContentType {
+		fmt.Println("failed")
+		fmt.Printf("[!] served with content type %q\n", ctype)
+		t.FailNow()
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE filecache_items gauge",
+		`filecache_items{cache="static"} 0`,
+		`filecache_hits_total{cache="static"} 1`,
+		`filecache_misses_total{cache="static"} 1`,
+		`filecache_hit_ratio{cache="static"} 0.5`,
+		`filecache_queue_length{cache="static"} 0`,
+		`filecache_evictions_total{cache="static",reason="manual"} 1`,
+		`filecache_evictions_total{cache="static",reason="ttl"} 0`,
+		`filecache_hits_total{cache="as\"sets"} 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			fmt.Println("failed")
+			fmt.Printf("[!] missing %q from:\n%s", line, body)
+			t.FailNow()
+		}
+	}
+
+	h.Unregister("static")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if strings.Contains(w.Body.String(), `cache="static"`) {
+		fmt.Println("failed")
+		fmt.Println("[!] unregistered cache is still served")
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}
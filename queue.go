@@ -0,0 +1,78 @@
+package filecache
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// A QueuePolicy decides what Cache does when the queue of files waiting to
+// be cached in the background is full.
+type QueuePolicy int
+
+const (
+	// QueueBlock waits for room in the queue.
+	QueueBlock QueuePolicy = iota
+
+	// QueueDrop drops the file being queued.
+	QueueDrop
+
+	// QueueDropOldest drops the file that has been queued the longest
+	// to make room for the file being queued. If the queue is unbuffered,
+	// it drops the file being queued, as QueueDrop does.
+	QueueDropOldest
+)
+
+// enqueue adds name to the queue of files to cache, following the cache's
+// QueueFull policy.
+func (cache *FileCache) enqueue(ctx context.Context, in chan string, shutdown chan interface{}, name string) error {
+	policy := cache.QueueFull
+	if policy == QueueDropOldest && cap(in) == 0 {
+		// Nothing waits in an unbuffered queue, so there is nothing
+		// older to drop.
+		policy = QueueDrop
+	}
+
+	switch policy {
+	case QueueDrop:
+		select {
+		case in <- name:
+		default:
+			cache.stats.add(&cache.stats.dropped, 1)
+		}
+		return nil
+	case QueueDropOldest:
+		for {
+			select {
+			case in <- name:
+				return nil
+			default:
+			}
+			// The queue is full: wait for room, or for a file to drop.
+			select {
+			case in <- name:
+				return nil
+			case <-in:
+				cache.stats.add(&cache.stats.dropped, 1)
+			case <-shutdown:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	select {
+	case in <- name:
+		return nil
+	case <-shutdown:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Dropped returns the number of files that Cache has dropped, rather than
+// queueing them to be cached, because the queue was full.
+func (cache *FileCache) Dropped() uint64 {
+	return atomic.LoadUint64(&cache.stats.dropped)
+}
@@ -0,0 +1,141 @@
+package filecache
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// startQueueCache starts a cache of slowly read files, with a queue of a
+// single file.
+func startQueueCache(t *testing.T, workers int, policy QueuePolicy) (*FileCache, *slowFS) {
+	fsys := &slowFS{delay: 100 * time.Millisecond, MapFS: fstest.MapFS{}}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+		fsys.MapFS[name] = &fstest.MapFile{Data: []byte(name)}
+	}
+	cache := NewFSCache(fsys)
+	cache.Workers = workers
+	cache.QueueFull = policy
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+		t.FailNow()
+	}
+	return cache, fsys
+}
+
+// waitForReads waits until n files have started being read from fsys.
+func waitForReads(t *testing.T, fsys *slowFS, n int64) {
+	for start := time.Now(); atomic.LoadInt64(&fsys.reads) < n; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			fmt.Println("failed")
+			fmt.Printf("[!] %d files should have been read\n", n)
+			t.FailNow()
+		}
+	}
+}
+
+func TestWorkers(t *testing.T) {
+	fmt.Printf("[+] testing background caching with several workers: ")
+	cache, fsys := startQueueCache(t, 4, QueueBlock)
+	defer cache.Stop()
+
+	names := []string{"a.txt", "b.txt", "c.txt", "d.txt"}
+	start := time.Now()
+	for _, name := range names {
+		cache.Cache(name)
+	}
+	// Each file takes 100ms to read, so one worker wouldn't start reading
+	// the last file for 300ms.
+	waitForReads(t, fsys, 4)
+	if time.Since(start) > 80*time.Millisecond {
+		fmt.Println("failed")
+		fmt.Println("[!] files should be read in parallel")
+		t.FailNow()
+	}
+	time.Sleep(150 * time.Millisecond)
+	for _, name := range names {
+		if !cache.InCache(name) {
+			fmt.Println("failed")
+			fmt.Printf("[!] %s should have been cached in parallel\n", name)
+			t.FailNow()
+		}
+	}
+	fmt.Println("ok")
+}
+
+func TestQueueFull(t *testing.T) {
+	fmt.Printf("[+] testing queue-full policies: ")
+	pipeSize := NewCachePipeSize
+	NewCachePipeSize = 1
+	defer func() { NewCachePipeSize = pipeSize }()
+
+	tests := []struct {
+		policy QueuePolicy
+		cached string
+		missed string
+	}{
+		{QueueDrop, "b.txt", "c.txt"},
+		{QueueDropOldest, "c.txt", "b.txt"},
+	}
+	for _, test := range tests {
+		cache, fsys := startQueueCache(t, 1, test.policy)
+
+		// The worker is busy with the first file, and the second fills
+		// the queue, so the third doesn't fit.
+		cache.Cache("a.txt")
+		waitForReads(t, fsys, 1)
+		cache.Cache("b.txt")
+		start := time.Now()
+		cache.Cache("c.txt")
+		if time.Since(start) > 50*time.Millisecond {
+			fmt.Println("failed")
+			fmt.Printf("[!] policy %d: Cache shouldn't wait when the queue is full\n", test.policy)
+			t.FailNow()
+		} else if cache.Dropped() != 1 {
+			fmt.Println("failed")
+			fmt.Printf("[!] policy %d: %d files dropped, expected 1\n", test.policy, cache.Dropped())
+			t.FailNow()
+		}
+
+		waitForReads(t, fsys, 2)
+		time.Sleep(150 * time.Millisecond)
+		if !cache.InCache(test.cached) || cache.InCache(test.missed) {
+			fmt.Println("failed")
+			fmt.Printf("[!] policy %d: %s should be cached and %s dropped\n",
+				test.policy, test.cached, test.missed)
+			t.FailNow()
+		}
+		cache.Stop()
+	}
+	fmt.Println("ok")
+}
+
+func TestQueueUnbuffered(t *testing.T) {
+	fmt.Printf("[+] testing dropping the oldest file from an unbuffered queue: ")
+	cache := NewDefaultCache()
+	cache.QueueFull = QueueDropOldest
+	in := make(chan string)
+	shutdown := make(chan interface{})
+
+	// With no worker ready, there is nothing to drop but the new file,
+	// rather than waiting for a worker.
+	done := make(chan error, 1)
+	go func() { done <- cache.enqueue(context.Background(), in, shutdown, "a.txt") }()
+	select {
+	case err := <-done:
+		if err != nil || cache.Dropped() != 1 {
+			fmt.Println("failed")
+			fmt.Printf("[!] enqueue returned %v, with %d files dropped\n", err, cache.Dropped())
+			t.FailNow()
+		}
+	case <-time.After(time.Second):
+		fmt.Println("failed")
+		fmt.Println("[!] enqueue didn't return")
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}
@@ -0,0 +1,99 @@
+package filecache
+
+import "sync"
+
+// shard holds a subset of the cache's items, chosen by a hash of their
+// names, with its own lock and eviction policy so that lookups of items
+// in different shards don't contend with each other.
+type shard struct {
+	mutex  sync.Mutex
+	items  map[string]*cacheItem
+	policy EvictionPolicy
+	bytes  int64 // total footprint of the items
+}
+
+func newShard(policy EvictionPolicy) *shard {
+	return &shard{
+		items:  make(map[string]*cacheItem),
+		policy: policy,
+	}
+}
+
+// shardHash returns the FNV-1a hash of name.
+func shardHash(name string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		h ^= uint32(name[i])
+		h *= 16777619
+	}
+	return h
+}
+
+func (s *shard) get(name string) (itm *cacheItem, ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	itm, ok = s.items[name]
+	return
+}
+
+// access is like get, but records the access with the eviction policy.
+func (s *shard) access(name string) (itm *cacheItem, ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	itm, ok = s.items[name]
+	if ok {
+		s.policy.Access(name)
+	}
+	return
+}
+
+// insert stores itm under name, replacing any item already there.
+func (s *shard) insert(name string, itm *cacheItem) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if old, ok := s.items[name]; ok {
+		s.bytes -= old.footprint()
+	}
+	s.items[name] = itm
+	s.bytes += itm.footprint()
+	s.policy.Insert(name)
+}
+
+// remove deletes name from the shard and the eviction policy, returning
+// the item that was removed. The policy is always told, so that it can
+// drop names it is still tracking for items that are already gone.
+func (s *shard) remove(name string) (itm *cacheItem, ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	itm, ok = s.items[name]
+	if ok {
+		s.bytes -= itm.footprint()
+		delete(s.items, name)
+	}
+	s.policy.Remove(name)
+	return
+}
+
+// victim returns the name the shard's eviction policy would evict next.
+func (s *shard) victim() (name string, ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.policy.Victim()
+}
+
+// size returns the number of items in the shard and their total footprint.
+func (s *shard) size() (items int, bytes int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.items), s.bytes
+}
+
+// names appends the names of the items in the shard to list.
+func (s *shard) names(list []string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for name := range s.items {
+		list = append(list, name)
+	}
+	return list
+}
@@ -0,0 +1,97 @@
+package filecache
+
+import (
+	"fmt"
+	"testing"
+	"testing/fstest"
+)
+
+func TestShards(t *testing.T) {
+	fmt.Printf("[+] testing sharded cache limits: ")
+	fsys := fstest.MapFS{}
+	for i := 0; i < 32; i++ {
+		fsys[fmt.Sprintf("file%d.txt", i)] = &fstest.MapFile{Data: []byte("0123456789")}
+	}
+	cache := NewFSCache(fsys)
+	cache.Shards = 4
+	cache.MaxItems = 8
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+		t.FailNow()
+	}
+	defer cache.Stop()
+
+	for name := range fsys {
+		if err := cache.CacheNow(name); err != nil {
+			fmt.Println("failed")
+			fmt.Printf("[!] failed to cache %s: %s\n", name, err.Error())
+			t.FailNow()
+		} else if cache.Size() > cache.MaxItems {
+			fmt.Println("failed")
+			fmt.Printf("[!] cache holds %d items, more than MaxItems\n", cache.Size())
+			t.FailNow()
+		}
+	}
+
+	used := 0
+	for _, s := range cache.getShards() {
+		if n, _ := s.size(); n > 0 {
+			used++
+		}
+	}
+	if len(cache.getShards()) != 4 || used < 2 {
+		fmt.Println("failed")
+		fmt.Printf("[!] items should be spread over shards, but %d were used\n", used)
+		t.FailNow()
+	} else if cache.Size() != 8 || len(cache.StoredFiles()) != 8 || cache.FileSize() != 80 {
+		fmt.Println("failed")
+		fmt.Printf("[!] cache should hold 8 items of 80 bytes, but holds %d of %d bytes\n",
+			cache.Size(), cache.FileSize())
+		t.FailNow()
+	}
+
+	for _, name := range cache.StoredFiles() {
+		if ok, _ := cache.Remove(name); !ok {
+			fmt.Println("failed")
+			fmt.Printf("[!] failed to remove %s\n", name)
+			t.FailNow()
+		}
+	}
+	if cache.Size() != 0 || cache.FileSize() != 0 {
+		fmt.Println("failed")
+		fmt.Println("[!] cache should be empty")
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}
+
+func benchmarkParallelGet(b *testing.B, shards int) {
+	cache := NewDefaultCache()
+	cache.Shards = shards
+	cache.MaxItems = 4096
+	if err := cache.Start(); err != nil {
+		fmt.Println("[!] cache failed to start: ", err.Error())
+	}
+	defer cache.Stop()
+	names := make([]string, 1024)
+	for i := range names {
+		names[i] = fmt.Sprintf("file%d", i)
+		cache.insertItem(names[i], &cacheItem{content: []byte(names[i])})
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			cache.GetItem(names[i%len(names)])
+		}
+	})
+}
+
+func BenchmarkParallelGet(b *testing.B) {
+	for _, shards := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			benchmarkParallelGet(b, shards)
+		})
+	}
+}
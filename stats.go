@@ -0,0 +1,118 @@
+package filecache
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// An EvictReason says why an item was removed from the cache.
+type EvictReason int
+
+const (
+	EvictModified EvictReason = iota // The file changed on disk
+	EvictTTL                         // The item wasn't accessed for ExpireItem seconds
+	EvictCapacity                    // Room was needed for another item
+	EvictManual                      // The item was removed with Remove or Stop
+	numEvictReasons
+)
+
+var evictReasons = [numEvictReasons]string{"modified", "ttl", "capacity", "manual"}
+
+func (reason EvictReason) String() string {
+	if reason < 0 || reason >= numEvictReasons {
+		return "unknown"
+	}
+	return evictReasons[reason]
+}
+
+// counters are the cache's statistics. They are updated atomically, and
+// must stay 64-bit aligned.
+type counters struct {
+	hits        uint64
+	misses      uint64
+	loads       uint64
+	loadErrors  uint64
+	loadTime    uint64 // nanoseconds
+	diskBytes   uint64
+	servedBytes uint64
+	dropped     uint64
+	evictions   [numEvictReasons]uint64
+}
+
+func (c *counters) add(counter *uint64, n int) {
+	atomic.AddUint64(counter, uint64(n))
+}
+
+// Stats is a snapshot of a cache's statistics. The counters run from when
+// the cache was created, across restarts.
+type Stats struct {
+	Hits        uint64                  // Lookups that found the file in the cache
+	Misses      uint64                  // Lookups that didn't
+	Loads       uint64                  // Files read into the cache
+	LoadErrors  uint64                  // Files that couldn't be read into the cache
+	LoadTime    time.Duration           // Total time spent loading files
+	Evictions   [numEvictReasons]uint64 // Items removed, indexed by EvictReason
+	DiskBytes   uint64                  // Bytes read from the file system by the cache
+	ServedBytes uint64                  // Bytes of cached files handed out
+	Dropped     uint64                  // Files dropped because the queue was full
+
+	Items  int   // Number of files in the cache
+	Bytes  int64 // Size of the files in the cache, as returned by FileSize
+	Queued int   // Number of files waiting to be cached in the background
+}
+
+// HitRatio returns the fraction of lookups that found the file in the
+// cache, or 0 if there have been no lookups.
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// MeanLoadTime returns the average time taken to load a file, including
+// failed loads.
+func (s Stats) MeanLoadTime() time.Duration {
+	if n := s.Loads + s.LoadErrors; n > 0 {
+		return s.LoadTime / time.Duration(n)
+	}
+	return 0
+}
+
+// Stats returns the cache's current statistics.
+func (cache *FileCache) Stats() Stats {
+	c := &cache.stats
+	s := Stats{
+		Hits:        atomic.LoadUint64(&c.hits),
+		Misses:      atomic.LoadUint64(&c.misses),
+		Loads:       atomic.LoadUint64(&c.loads),
+		LoadErrors:  atomic.LoadUint64(&c.loadErrors),
+		LoadTime:    time.Duration(atomic.LoadUint64(&c.loadTime)),
+		DiskBytes:   atomic.LoadUint64(&c.diskBytes),
+		ServedBytes: atomic.LoadUint64(&c.servedBytes),
+		Dropped:     atomic.LoadUint64(&c.dropped),
+		Items:       cache.Size(),
+		Bytes:       cache.FileSize(),
+	}
+	for i := range s.Evictions {
+		s.Evictions[i] = atomic.LoadUint64(&c.evictions[i])
+	}
+	cache.lock()
+	s.Queued = len(cache.in)
+	cache.unlock()
+	return s
+}
+
+// countingWriter is an http.ResponseWriter that counts the bytes of the
+// body written to it.
+type countingWriter struct {
+	http.ResponseWriter
+	n int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += n
+	return n, err
+}
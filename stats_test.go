@@ -0,0 +1,134 @@
+package filecache
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	fmt.Printf("[+] testing cache statistics: ")
+	content := []byte("hello")
+	fsys := fstest.MapFS{
+		"a.txt": {Data: content},
+		"b.txt": {Data: content},
+		"c.txt": {Data: content},
+		"d.txt": {Data: content},
+	}
+	cache := NewFSCache(fsys)
+	cache.MaxItems = 2
+	cache.ExpireItem = 1
+	cache.Every = 0
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+		t.FailNow()
+	}
+	defer cache.Stop()
+
+	cache.ReadFile("a.txt")
+	cache.ReadFile("a.txt")
+	cache.ReadFile("missing.txt")
+	cache.ReadFile("b.txt")
+	cache.ReadFile("c.txt")
+
+	// Remove one of the two files left by hand, and modify the other.
+	stored := cache.StoredFiles()
+	if len(stored) != 2 {
+		fmt.Println("failed")
+		fmt.Printf("[!] expected 2 files in the cache, found %d\n", len(stored))
+		t.FailNow()
+	}
+	cache.Remove(stored[0])
+	fsys[stored[1]].ModTime = time.Now().Add(time.Hour)
+	cache.InCache(stored[1])
+
+	// Let the next file expire, and reload it.
+	cache.ReadFile("d.txt")
+	if itm, ok := cache.getItem("d.txt"); ok {
+		itm.Lastaccess = time.Now().Add(-2 * time.Second)
+	}
+	cache.addItem("d.txt", true)
+
+	stats := cache.Stats()
+	expect := Stats{
+		Hits:        1,
+		Misses:      5,
+		Loads:       5,
+		LoadErrors:  1,
+		DiskBytes:   25,
+		ServedBytes: 25,
+		Items:       1,
+		Bytes:       5,
+	}
+	for i := range expect.Evictions {
+		expect.Evictions[i] = 1
+	}
+	expect.LoadTime = stats.LoadTime
+	if stats != expect {
+		fmt.Println("failed")
+		fmt.Printf("[!] expected %+v\n", expect)
+		fmt.Printf("[!]      got %+v\n", stats)
+		t.FailNow()
+	} else if ratio := stats.HitRatio(); ratio != 1.0/6 {
+		fmt.Println("failed")
+		fmt.Printf("[!] expected a hit ratio of %f, got %f\n", 1.0/6, ratio)
+		t.FailNow()
+	} else if stats.LoadTime <= 0 || stats.MeanLoadTime() != stats.LoadTime/6 {
+		fmt.Println("failed")
+		fmt.Printf("[!] bad load times: %v total, %v mean\n",
+			stats.LoadTime, stats.MeanLoadTime())
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}
+
+func TestStatsModified(t *testing.T) {
+	fmt.Printf("[+] testing statistics for files changed on disk: ")
+	fsys := fstest.MapFS{"a.txt": {Data: []byte("hello")}}
+	cache := NewFSCache(fsys)
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+		t.FailNow()
+	}
+	defer cache.Stop()
+
+	var hits int
+	cache.OnHit(func(string) { hits++ })
+	cache.ReadFile("a.txt")
+	fsys["a.txt"].ModTime = time.Now().Add(time.Hour)
+	cache.HttpWriteFile(httptest.NewRecorder(), httptest.NewRequest("GET", "/a.txt", nil))
+
+	// The stale item is a miss, not a hit.
+	stats := cache.Stats()
+	if stats.Hits != 0 || hits != 0 || stats.Misses != 2 || stats.Loads != 2 ||
+		stats.Evictions[EvictModified] != 1 {
+		fmt.Println("failed")
+		fmt.Printf("[!] got %d hits (%d hooks), %d misses, %d loads, %d evictions\n",
+			stats.Hits, hits, stats.Misses, stats.Loads, stats.Evictions[EvictModified])
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}
+
+func TestEvictReasonString(t *testing.T) {
+	fmt.Printf("[+] testing eviction reason names: ")
+	names := map[EvictReason]string{
+		EvictModified:   "modified",
+		EvictTTL:        "ttl",
+		EvictCapacity:   "capacity",
+		EvictManual:     "manual",
+		numEvictReasons: "unknown",
+	}
+	for reason, name := range names {
+		if reason.String() != name {
+			fmt.Println("failed")
+			fmt.Printf("[!] %d should be %q, not %q\n", reason, name, reason.String())
+			t.FailNow()
+		}
+	}
+	fmt.Println("ok")
+}
@@ -0,0 +1,244 @@
+package filecache
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"math/rand"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// The stress tests hammer a cache from many goroutines at once. They are
+// most useful under the race detector:
+//
+//	go test -race -run Stress
+
+const (
+	stressWorkers = 16
+	stressRounds  = 1000
+)
+
+// stressOp is one operation on the cache, acting on the file named by
+// 'name'. It returns an error describing any wrong result.
+type stressOp func(cache *FileCache, name string) error
+
+// checkContent returns an error if content isn't the content of the file
+// named by 'name', as returned by the operation op.
+func checkContent(cache *FileCache, op, name string, content []byte) error {
+	var expected []byte
+	var err error
+	if cache.fsys != nil {
+		expected, err = fs.ReadFile(cache.fsys, name)
+	} else {
+		expected, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		return err
+	} else if !bytes.Equal(content, expected) {
+		return fmt.Errorf("%s(%s) returned %q, not %q", op, name, content, expected)
+	}
+	return nil
+}
+
+var stressOps = []stressOp{
+	func(cache *FileCache, name string) error {
+		content, err := cache.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("ReadFile(%s): %v", name, err)
+		}
+		return checkContent(cache, "ReadFile", name, content)
+	},
+	func(cache *FileCache, name string) error {
+		buf := new(bytes.Buffer)
+		if err := cache.WriteFile(buf, name); err != nil {
+			return fmt.Errorf("WriteFile(%s): %v", name, err)
+		}
+		return checkContent(cache, "WriteFile", name, buf.Bytes())
+	},
+	func(cache *FileCache, name string) error {
+		cache.GetItem(name)
+		cache.GetItemString(name)
+		cache.InCache(name)
+		return nil
+	},
+	func(cache *FileCache, name string) error {
+		return cache.WriteItem(ioutil.Discard, name)
+	},
+	func(cache *FileCache, name string) error {
+		w := httptest.NewRecorder()
+		cache.HttpWriteFile(w, httptest.NewRequest("GET", "/"+filepath.ToSlash(name), nil))
+		if w.Code != 200 {
+			return fmt.Errorf("HttpWriteFile(%s) returned %d", name, w.Code)
+		}
+		return nil
+	},
+	func(cache *FileCache, name string) error {
+		cache.Cache(name)
+		return nil
+	},
+	func(cache *FileCache, name string) error {
+		if err := cache.CacheNow(name); err != nil && err != ItemNotInCache {
+			return fmt.Errorf("CacheNow(%s): %v", name, err)
+		}
+		return nil
+	},
+	func(cache *FileCache, name string) error {
+		_, err := cache.Remove(name)
+		return err
+	},
+	func(cache *FileCache, name string) error {
+		cache.Size()
+		cache.FileSize()
+		cache.StoredFiles()
+		cache.Active()
+		return nil
+	},
+}
+
+// stress runs stressRounds operations in each of stressWorkers goroutines on
+// randomly chosen files.
+func stress(t *testing.T, cache *FileCache, names []string, extra ...stressOp) {
+	ops := append(append([]stressOp(nil), stressOps...), extra...)
+	errs := make(chan error, stressWorkers)
+	var wg sync.WaitGroup
+	for i := 0; i < stressWorkers; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for j := 0; j < stressRounds; j++ {
+				op := ops[rnd.Intn(len(ops))]
+				if err := op(cache, names[rnd.Intn(len(names))]); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		fmt.Println("failed")
+		fmt.Println("[!]", err.Error())
+		t.FailNow()
+	}
+
+	if cache.Size() > cache.MaxItems {
+		fmt.Println("failed")
+		fmt.Printf("[!] cache holds %d items, more than MaxItems\n", cache.Size())
+		t.FailNow()
+	}
+}
+
+func TestStressFS(t *testing.T) {
+	fmt.Printf("[+] stress testing a sharded fs.FS cache: ")
+	fsys := fstest.MapFS{}
+	var names []string
+	for i := 0; i < 64; i++ {
+		name := fmt.Sprintf("static/file%d.txt", i)
+		fsys[name] = &fstest.MapFile{Data: bytes.Repeat([]byte{byte('a' + i%26)}, 100+i)}
+		names = append(names, name)
+	}
+	cache := NewFSCache(fsys)
+	cache.MaxItems = 16
+	cache.Shards = 4
+	cache.Admission = NewTinyLFU(cache.MaxItems)
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+		t.FailNow()
+	}
+	defer cache.Stop()
+
+	stress(t, cache, names,
+		func(cache *FileCache, name string) error {
+			f, err := cache.Open(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = ioutil.ReadAll(f)
+			return err
+		},
+		func(cache *FileCache, name string) error {
+			_, err := cache.Stat(name)
+			return err
+		})
+	fmt.Println("ok")
+}
+
+func TestStressFiles(t *testing.T) {
+	fmt.Printf("[+] stress testing a cache of changing files: ")
+	dir, err := ioutil.TempDir("", "fctest")
+	if err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] couldn't create temporary directory: ", err.Error())
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	var names []string
+	for i := 0; i < 32; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("file%d.txt", i))
+		if err = ioutil.WriteFile(name, []byte(name), 0600); err != nil {
+			fmt.Println("failed")
+			fmt.Println("[!] couldn't write temporary file: ", err.Error())
+			t.FailNow()
+		}
+		names = append(names, name)
+	}
+
+	cache := NewDefaultCache()
+	cache.MaxItems = 8
+	cache.MaxBytes = 8 * int64(len(names[0])+2)
+	cache.Every = 1
+	cache.ExpireItem = 1
+	cache.Watch = true
+	cache.Eviction = NewARCPolicy
+	if err = cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+		t.FailNow()
+	}
+	defer cache.Stop()
+
+	// Rewrite files while they are being read, so that the watcher and
+	// modification checks remove items from under the readers.
+	stress(t, cache, names, func(cache *FileCache, name string) error {
+		now := time.Now()
+		return os.Chtimes(name, now, now)
+	})
+	fmt.Println("ok")
+}
+
+func TestStressStartStop(t *testing.T) {
+	fmt.Printf("[+] stress testing restarting a cache in use: ")
+	fsys := fstest.MapFS{
+		"a.txt": {Data: []byte("a")},
+		"b.txt": {Data: []byte("b")},
+	}
+	cache := NewFSCache(fsys)
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+		t.FailNow()
+	}
+	defer cache.Stop()
+
+	var mutex sync.Mutex
+	stress(t, cache, []string{"a.txt", "b.txt"}, func(cache *FileCache, name string) error {
+		// Start and Stop aren't meant to be called concurrently with
+		// each other, only with the cache's other methods.
+		mutex.Lock()
+		defer mutex.Unlock()
+		cache.Stop()
+		return cache.Start()
+	})
+	fmt.Println("ok")
+}
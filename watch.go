@@ -0,0 +1,92 @@
+package filecache
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// Interval between checks of cached files when the watcher has to fall
+// back to polling. It is read when the cache is started.
+var WatchPollInterval = 1 * time.Second
+
+// A watcher notifies the cache when a watched file is changed, renamed or
+// removed, by calling the invalidate function it was created with. The
+// invalidate function is never called with the watcher's lock held, so it
+// is free to call Remove.
+type watcher interface {
+	Add(name string) error
+	Remove(name string)
+	Close() error
+}
+
+// pollWatcher is the portable watcher: it periodically stats every watched
+// file and compares the result to the file as it was when it was added.
+type pollWatcher struct {
+	mutex      sync.Mutex
+	files      map[string]os.FileInfo
+	invalidate func(string)
+	done       chan struct{}
+}
+
+func newPollWatcher(invalidate func(string)) *pollWatcher {
+	w := &pollWatcher{
+		files:      make(map[string]os.FileInfo),
+		invalidate: invalidate,
+		done:       make(chan struct{}),
+	}
+	go w.run(WatchPollInterval)
+	return w
+}
+
+func (w *pollWatcher) Add(name string) error {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.files[name] = fi
+	return nil
+}
+
+func (w *pollWatcher) Remove(name string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	delete(w.files, name)
+}
+
+func (w *pollWatcher) Close() error {
+	close(w.done)
+	return nil
+}
+
+func (w *pollWatcher) run(interval time.Duration) {
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-time.After(interval):
+			w.poll()
+		}
+	}
+}
+
+func (w *pollWatcher) poll() {
+	w.mutex.Lock()
+	files := make(map[string]os.FileInfo, len(w.files))
+	for name, fi := range w.files {
+		files[name] = fi
+	}
+	w.mutex.Unlock()
+
+	for name, old := range files {
+		fi, err := os.Stat(name)
+		if err == nil && os.SameFile(fi, old) && fi.Size() == old.Size() &&
+			fi.ModTime().Equal(old.ModTime()) {
+			continue
+		}
+		w.Remove(name)
+		w.invalidate(name)
+	}
+}
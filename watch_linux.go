@@ -0,0 +1,196 @@
+//go:build linux
+
+package filecache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Events on a watched directory that might mean a file in it has changed.
+const inotifyMask = syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// inotifyWatcher watches the directories containing cached files, which
+// catches files being replaced by a rename as well as being written to.
+// If a cached file is a symlink, the directory containing its target is
+// watched as well.
+type inotifyWatcher struct {
+	fd         int
+	file       *os.File
+	mutex      sync.Mutex
+	closed     bool
+	dirs       map[string]int32           // directory -> watch descriptor
+	wds        map[int32]string           // watch descriptor -> directory
+	refs       map[string]int             // directory -> watched paths in it
+	paths      map[string]map[string]bool // absolute path -> cache names
+	names      map[string][]string        // cache name -> absolute paths
+	invalidate func(string)
+}
+
+// newWatcher returns an inotify based watcher, or a polling watcher if
+// inotify isn't available.
+func newWatcher(invalidate func(string)) watcher {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return newPollWatcher(invalidate)
+	}
+	w := &inotifyWatcher{
+		fd:         fd,
+		file:       os.NewFile(uintptr(fd), "inotify"),
+		dirs:       make(map[string]int32),
+		wds:        make(map[int32]string),
+		refs:       make(map[string]int),
+		paths:      make(map[string]map[string]bool),
+		names:      make(map[string][]string),
+		invalidate: invalidate,
+	}
+	go w.run()
+	return w
+}
+
+func (w *inotifyWatcher) Add(name string) error {
+	path, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+	paths := []string{path}
+	if target, err := filepath.EvalSymlinks(path); err == nil && target != path {
+		paths = append(paths, target)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	} else if _, ok := w.names[name]; ok {
+		return nil
+	}
+	for i, path := range paths {
+		if err = w.addPath(path, name); err != nil {
+			for _, added := range paths[:i] {
+				w.removePath(added, name)
+			}
+			return err
+		}
+	}
+	w.names[name] = paths
+	return nil
+}
+
+func (w *inotifyWatcher) addPath(path, name string) error {
+	dir := filepath.Dir(path)
+	if _, ok := w.dirs[dir]; !ok {
+		wd, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+		if err != nil {
+			return err
+		}
+		w.dirs[dir] = int32(wd)
+		w.wds[int32(wd)] = dir
+	}
+	w.refs[dir]++
+	if w.paths[path] == nil {
+		w.paths[path] = make(map[string]bool)
+	}
+	w.paths[path][name] = true
+	return nil
+}
+
+func (w *inotifyWatcher) Remove(name string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	paths, ok := w.names[name]
+	if !ok {
+		return
+	}
+	delete(w.names, name)
+	for _, path := range paths {
+		w.removePath(path, name)
+	}
+}
+
+func (w *inotifyWatcher) removePath(path, name string) {
+	if delete(w.paths[path], name); len(w.paths[path]) == 0 {
+		delete(w.paths, path)
+	}
+	dir := filepath.Dir(path)
+	if w.refs[dir]--; w.refs[dir] > 0 {
+		return
+	}
+	delete(w.refs, dir)
+	if wd, ok := w.dirs[dir]; ok {
+		if !w.closed {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+		}
+		delete(w.dirs, dir)
+		delete(w.wds, wd)
+	}
+}
+
+func (w *inotifyWatcher) Close() error {
+	w.mutex.Lock()
+	w.closed = true
+	w.mutex.Unlock()
+	return w.file.Close()
+}
+
+func (w *inotifyWatcher) run() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, name := range w.affected(buf[:n]) {
+			w.invalidate(name)
+		}
+	}
+}
+
+// affected returns the cache names affected by the events in buf.
+func (w *inotifyWatcher) affected(buf []byte) (names []string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for off := 0; off+syscall.SizeofInotifyEvent <= len(buf); {
+		ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+		off += syscall.SizeofInotifyEvent
+		file := strings.TrimRight(string(buf[off:off+int(ev.Len)]), "\x00")
+		off += int(ev.Len)
+
+		dir, ok := w.wds[ev.Wd]
+		switch {
+		case ev.Mask&syscall.IN_Q_OVERFLOW != 0:
+			// Events were lost, so anything could have changed.
+			for name := range w.names {
+				names = append(names, name)
+			}
+		case !ok:
+			continue
+		case file != "":
+			for name := range w.paths[filepath.Join(dir, file)] {
+				names = append(names, name)
+			}
+		case ev.Mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF|syscall.IN_IGNORED) != 0:
+			// The directory itself has gone away.
+			for path, watched := range w.paths {
+				if filepath.Dir(path) != dir {
+					continue
+				}
+				for name := range watched {
+					names = append(names, name)
+				}
+			}
+			if ev.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, dir)
+				delete(w.wds, ev.Wd)
+			}
+		}
+	}
+	return
+}
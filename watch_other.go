@@ -0,0 +1,7 @@
+//go:build !linux
+
+package filecache
+
+func newWatcher(invalidate func(string)) watcher {
+	return newPollWatcher(invalidate)
+}
@@ -0,0 +1,147 @@
+package filecache
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// waitForEviction polls the cache until name is no longer stored, without
+// going through InCache (which would stat the file itself).
+func waitForEviction(cache *FileCache, name string, timeout time.Duration) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); {
+		if _, ok := cache.getItem(name); !ok {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// testWatchMode checks that a started cache in watch mode invalidates
+// files as they are modified or replaced.
+func testWatchMode(t *testing.T, cache *FileCache) {
+	modified := writeTempFile(t, "this file will be modified")
+	removed := writeTempFile(t, "this file will be removed")
+	replacement := writeTempFile(t, "this file will replace another")
+	names := []string{modified, removed, replacement}
+	if t.Failed() {
+		cache.Stop()
+		destroyNames(names)
+		t.FailNow()
+	}
+
+	cache.CacheNow(modified)
+	cache.CacheNow(removed)
+	if itm, ok := cache.getItem(modified); !ok || !itm.watched {
+		fmt.Println("failed")
+		fmt.Println("[!] item should be watched")
+		cache.Stop()
+		destroyNames(names)
+		t.FailNow()
+	}
+
+	err := ioutil.WriteFile(modified, []byte("after modification"), 0600)
+	if err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] couldn't write temporary file: ", err.Error())
+		t.Fail()
+	} else if !waitForEviction(cache, modified, 3*time.Second) {
+		fmt.Println("failed")
+		fmt.Println("[!] modified item should have been invalidated")
+		t.Fail()
+	} else if err = os.Rename(replacement, removed); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] couldn't rename temporary file: ", err.Error())
+		t.Fail()
+	} else if !waitForEviction(cache, removed, 3*time.Second) {
+		fmt.Println("failed")
+		fmt.Println("[!] replaced item should have been invalidated")
+		t.Fail()
+	} else {
+		fmt.Println("ok")
+	}
+	cache.Stop()
+	destroyNames(names)
+}
+
+func TestWatch(t *testing.T) {
+	fmt.Printf("[+] validating watched files are invalidated on change: ")
+	cache := NewDefaultCache()
+	cache.Watch = true
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+	}
+	testWatchMode(t, cache)
+}
+
+func TestWatchPolling(t *testing.T) {
+	fmt.Printf("[+] validating polling watcher invalidates changed files: ")
+	interval := WatchPollInterval
+	WatchPollInterval = 50 * time.Millisecond
+	defer func() { WatchPollInterval = interval }()
+
+	cache := NewDefaultCache()
+	cache.Watch = true
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+	}
+	cache.lock()
+	cache.watcher.Close()
+	cache.watcher = newPollWatcher(cache.invalidatePath)
+	cache.unlock()
+	testWatchMode(t, cache)
+}
+
+// changingFS is a file system whose files change as they are read: it
+// calls changed with the name of each file read, after reading it.
+type changingFS struct {
+	fstest.MapFS
+	changed func(name string)
+}
+
+func (fsys *changingFS) ReadFile(name string) ([]byte, error) {
+	content, err := fs.ReadFile(fsys.MapFS, name)
+	fsys.changed(name)
+	return content, err
+}
+
+func TestChangeDuringLoad(t *testing.T) {
+	fmt.Printf("[+] validating files changed while loading aren't cached: ")
+	fsys := &changingFS{MapFS: fstest.MapFS{"app.js": {Data: []byte("old")}}}
+	cache := NewFSCache(fsys)
+	if err := cache.Start(); err != nil {
+		fmt.Println("failed")
+		fmt.Println("[!] cache failed to start: ", err.Error())
+		t.FailNow()
+	}
+	defer cache.Stop()
+
+	// The watcher reports the change before the item has been stored.
+	fsys.changed = cache.invalidate
+	cache.CacheNow("app.js")
+	if _, ok := cache.getItem("app.js"); ok {
+		fmt.Println("failed")
+		fmt.Println("[!] file changed while loading should have been dropped")
+		t.FailNow()
+	} else if n := cache.Stats().Evictions[EvictModified]; n != 1 {
+		fmt.Println("failed")
+		fmt.Printf("[!] expected 1 eviction for modification, got %d\n", n)
+		t.FailNow()
+	}
+
+	fsys.changed = func(string) {}
+	cache.CacheNow("app.js")
+	if _, ok := cache.getItem("app.js"); !ok {
+		fmt.Println("failed")
+		fmt.Println("[!] unchanged file should be cached")
+		t.FailNow()
+	}
+	fmt.Println("ok")
+}